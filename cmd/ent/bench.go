@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	cid "github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/zenground0/ent/lib"
+)
+
+var benchCmd = &cli.Command{
+	Name:        "bench",
+	Description: "benchmark migrations over repeated runs",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "migrate",
+			Usage:  "migrate a single state tree repeatedly and report timing statistics",
+			Action: runBenchMigrateCmd,
+			Flags: []cli.Flag{
+				&cli.IntFlag{Name: "runs", Value: 5, Usage: "number of times to migrate the state"},
+				&cli.BoolFlag{Name: "cold", Usage: "discard all buffered state before every run instead of preloading the input"},
+				&cli.StringFlag{Name: "preload", Usage: "state root to preload for warm runs, defaults to the input state root"},
+				&cli.StringFlag{Name: "baseline", Usage: "compare against a benchmark result saved with --save"},
+				&cli.Float64Flag{Name: "tolerance", Value: 0.1, Usage: "fraction by which a time may exceed the baseline before it is a regression"},
+				&cli.StringFlag{Name: "save", Usage: "write the benchmark result as JSON to this path"},
+				migrationFlag,
+			},
+		},
+	},
+}
+
+func runBenchMigrateCmd(c *cli.Context) error {
+	if c.Args().Len() != 2 {
+		return xerrors.Errorf("not enough args, need state root to migrate and height of state")
+	}
+	runs := c.Int("runs")
+	if runs < 1 {
+		return xerrors.Errorf("need at least one run, got %d", runs)
+	}
+	cleanUp, err := startProfiles(c)
+	if err != nil {
+		return err
+	}
+	defer cleanUp()
+	stateRootIn, err := cid.Decode(c.Args().First())
+	if err != nil {
+		return err
+	}
+	hRaw, err := strconv.Atoi(c.Args().Get(1))
+	if err != nil {
+		return err
+	}
+	height := abi.ChainEpoch(int64(hRaw))
+	mig, err := lib.GetMigration(c.String("migration"))
+	if err != nil {
+		return err
+	}
+	var baseline *lib.BenchResult
+	if path := c.String("baseline"); path != "" {
+		if baseline, err = lib.LoadBenchResult(path); err != nil {
+			return err
+		}
+	}
+
+	chn := lib.Chain{}
+	store, err := chn.LoadCborStore(c.Context)
+	if err != nil {
+		return err
+	}
+	stateRootIn, err = lib.LoadActorsRoot(c.Context, store, stateRootIn)
+	if err != nil {
+		return err
+	}
+	if err := mig.CheckInput(c.Context, store, stateRootIn); err != nil {
+		return xerrors.Errorf("cannot run migration %s: %w", mig.Name, err)
+	}
+	cold := c.Bool("cold")
+	if !cold {
+		preloadStr := c.String("preload")
+		if preloadStr == "" {
+			preloadStr = stateRootIn.String()
+		}
+		if err := maybePreload(c.Context, &chn, preloadStr); err != nil {
+			return err
+		}
+	}
+
+	var migrateTimes, flushTimes []time.Duration
+	for i := 0; i < runs; i++ {
+		if err := chn.DiscardBufferedState(c.Context); err != nil {
+			return err
+		}
+		if cold {
+			if err := chn.DiscardReadOnlyBuffer(c.Context); err != nil {
+				return err
+			}
+		}
+		start := time.Now()
+		stateRootOut, err := mig.Migrate(c.Context, store, stateRootIn, height)
+		duration := time.Since(start)
+		if err != nil {
+			return xerrors.Errorf("run %d: %w", i, err)
+		}
+		if err := mig.CheckOutput(c.Context, store, stateRootOut); err != nil {
+			return xerrors.Errorf("run %d: %w", i, err)
+		}
+		writeStart := time.Now()
+		if err := chn.FlushBufferedState(c.Context, stateRootOut); err != nil {
+			return xerrors.Errorf("run %d: failed to flush state tree to disk: %w", i, err)
+		}
+		writeDuration := time.Since(writeStart)
+		fmt.Printf("run %d: %s => %s -- %v -- flush %v\n", i, stateRootIn, stateRootOut, duration, writeDuration)
+		migrateTimes = append(migrateTimes, duration)
+		flushTimes = append(flushTimes, writeDuration)
+	}
+
+	result := &lib.BenchResult{
+		StateRoot: stateRootIn,
+		Epoch:     height,
+		Migration: mig.Name,
+		Runs:      runs,
+		Cold:      cold,
+		Migrate:   lib.NewDurationStats(migrateTimes),
+		Flush:     lib.NewDurationStats(flushTimes),
+	}
+	printDurationStats("migrate", result.Migrate)
+	printDurationStats("flush", result.Flush)
+	if path := c.String("save"); path != "" {
+		if err := lib.WriteBenchResult(path, result); err != nil {
+			return err
+		}
+	}
+
+	if baseline == nil {
+		return nil
+	}
+	if !baseline.StateRoot.Equals(result.StateRoot) || baseline.Cold != result.Cold {
+		fmt.Printf("warning: baseline measured %s (cold: %t), this run measured %s (cold: %t)\n",
+			baseline.StateRoot, baseline.Cold, result.StateRoot, result.Cold)
+	}
+	regressions := lib.CompareBench(baseline, result, c.Float64("tolerance"))
+	for _, r := range regressions {
+		fmt.Printf("REGRESSION %s\n", r)
+	}
+	if len(regressions) > 0 {
+		return xerrors.Errorf("%d benchmark times regressed against baseline %s", len(regressions), c.String("baseline"))
+	}
+	fmt.Printf("no regressions against baseline %s\n", c.String("baseline"))
+	return nil
+}
+
+func printDurationStats(name string, s lib.DurationStats) {
+	fmt.Printf("%s -- mean: %v, stddev: %v, p50: %v, p95: %v, min: %v, max: %v\n",
+		name, s.Mean, s.Stddev, s.P50, s.P95, s.Min, s.Max)
+}
@@ -4,24 +4,30 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"runtime"
 	"runtime/pprof"
+	"runtime/trace"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	address "github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/big"
+	lbuiltin "github.com/filecoin-project/lotus/chain/actors/builtin"
 	"github.com/filecoin-project/lotus/chain/types"
 	adt0 "github.com/filecoin-project/specs-actors/actors/util/adt"
-	builtin2 "github.com/filecoin-project/specs-actors/v2/actors/builtin"
-	migration2 "github.com/filecoin-project/specs-actors/v2/actors/migration"
 	states2 "github.com/filecoin-project/specs-actors/v2/actors/states"
 	cid "github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	cbornode "github.com/ipfs/go-ipld-cbor"
 	"github.com/urfave/cli/v2"
 	"golang.org/x/xerrors"
@@ -40,6 +46,16 @@ var migrateCmd = &cli.Command{
 			Flags: []cli.Flag{
 				&cli.StringFlag{Name: "preload"},
 				&cli.BoolFlag{Name: "validate"},
+				preValidateFlag,
+				validationBaselineFlag,
+				networkFlag,
+				totalSupplyFlag,
+				auditFlag,
+				migrationFlag,
+				cacheFlag,
+				cacheFileFlag,
+				cacheCheckFlag,
+				actorProfileFlag,
 			},
 		},
 		{
@@ -50,11 +66,113 @@ var migrateCmd = &cli.Command{
 				&cli.StringFlag{Name: "preload"},
 				&cli.IntFlag{Name: "skip", Aliases: []string{"k"}},
 				&cli.BoolFlag{Name: "validate"},
+				preValidateFlag,
+				validationBaselineFlag,
+				networkFlag,
+				totalSupplyFlag,
+				auditFlag,
+				migrationFlag,
+				cacheFlag,
+				cacheFileFlag,
+				cacheCheckFlag,
+				actorProfileFlag,
+			},
+		},
+		{
+			Name:   "check",
+			Usage:  "re-run migrations recorded in a golden file and compare output roots",
+			Action: runMigrateCheckCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "golden", Required: true, Usage: "JSON file of migration input roots, epochs and expected output roots"},
+				&cli.BoolFlag{Name: "update", Usage: "overwrite golden output roots with the roots computed now"},
+				migrationFlag,
+			},
+		},
+		{
+			Name:   "bisect",
+			Usage:  "binary search a chain range for the first state whose migration fails",
+			Action: runMigrateBisectCmd,
+			Flags: []cli.Flag{
+				&cli.Int64Flag{Name: "from", Required: true},
+				&cli.Int64Flag{Name: "to", Required: true},
+				&cli.BoolFlag{Name: "validate", Usage: "also count states whose migration output fails validation as failing"},
+				networkFlag,
+				totalSupplyFlag,
+				migrationFlag,
+			},
+		},
+		{
+			Name:   "list",
+			Usage:  "list the migrations that can be selected with --migration",
+			Action: runMigrateListCmd,
+		},
+		{
+			Name:   "actor",
+			Usage:  "migrate a single actor and check its invariants",
+			Action: runMigrateActorCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "preload"},
+				migrationFlag,
 			},
 		},
 	},
 }
 
+// memSampleInterval is how often heap statistics are sampled while a
+// migration runs.
+const memSampleInterval = 100 * time.Millisecond
+
+var migrationFlag = &cli.StringFlag{
+	Name:  "migration",
+	Usage: "name of the registered migration to run",
+	Value: lib.DefaultMigration,
+}
+
+var preValidateFlag = &cli.BoolFlag{
+	Name:  "pre-validate",
+	Usage: "check invariants of the input state before migrating it",
+}
+
+var validationBaselineFlag = &cli.StringFlag{
+	Name:  "baseline",
+	Usage: "accept invariant violations recorded in this validation results file and only fail on new ones",
+}
+
+var networkFlag = &cli.StringFlag{
+	Name:  "network",
+	Usage: "network the state belongs to, detected from the init actor's network name if not given",
+}
+
+var totalSupplyFlag = &cli.StringFlag{
+	Name:  "total-supply",
+	Usage: "override the network's expected total FIL supply, e.g. \"2000000000 FIL\"",
+}
+
+var auditFlag = &cli.BoolFlag{
+	Name:  "audit",
+	Usage: "compare balances, actors, power, sectors and deals of the migration input and output",
+}
+
+var cacheFlag = &cli.BoolFlag{
+	Name:  "cache",
+	Usage: "reuse per-actor migration results of earlier migrations and report the cache hit rate",
+}
+
+var cacheFileFlag = &cli.StringFlag{
+	Name:  "cache-file",
+	Usage: "load and save the migration cache at this path, implies --cache",
+}
+
+var cacheCheckFlag = &cli.BoolFlag{
+	Name:  "cache-check",
+	Usage: "also run every cached migration in full, check both give the same root and report the time saved",
+}
+
+var actorProfileFlag = &cli.StringFlag{
+	Name:  "actor-profile",
+	Usage: "migrate on a single worker, time every actor's migration, print a breakdown by actor type and write it as JSON to this path",
+}
+
 var validateCmd = &cli.Command{
 	Name:        "validate",
 	Description: "validate a statetree by checking lots of invariants",
@@ -62,14 +180,47 @@ var validateCmd = &cli.Command{
 		{
 			Name:   "v2",
 			Usage:  "validate a single v2 state tree",
-			Action: runValidateCmd,
-			Flags: []cli.Flag{
-				&cli.StringFlag{Name: "preload"},
-			},
+			Action: runValidateV2Cmd,
+			Flags: append([]cli.Flag{
+				&cli.StringSliceFlag{Name: "only", Usage: "only run checks of these actor types, e.g. miner,market, and cross-actor checks involving them"},
+				&cli.StringSliceFlag{Name: "actor", Usage: "only run checks of the actor at this address, may be repeated"},
+				&cli.StringFlag{Name: "estimate-from", Usage: "estimate time saved by --only and --actor from a full validation saved with --save instead of from the actors checked"},
+			}, validateFlags...),
+		},
+		{
+			Name:   "v0",
+			Usage:  "validate a single v0 state tree",
+			Action: runValidateV0Cmd,
+			Flags:  validateFlags,
+		},
+		{
+			Name:   "chain",
+			Usage:  "validate the state trees of a range of epochs from given chain head, in parallel",
+			Action: runValidateChainCmd,
+			Flags: append([]cli.Flag{
+				&cli.Int64Flag{Name: "from", Usage: "lowest epoch to validate"},
+				&cli.Int64Flag{Name: "to", Usage: "highest epoch to validate, defaults to the chain head", Value: math.MaxInt64},
+				&cli.IntFlag{Name: "skip", Aliases: []string{"k"}, Usage: "only validate every k-th epoch"},
+				&cli.IntFlag{Name: "workers", Usage: "number of states validated concurrently", Value: runtime.NumCPU()},
+			}, validateFlags...),
+		},
+		{
+			Name:   "audit",
+			Usage:  "check that a v0 to v2 migration conserved funds, actors, power, sectors and deals",
+			Action: runValidateAuditCmd,
 		},
 	},
 }
 
+var validateFlags = []cli.Flag{
+	&cli.StringFlag{Name: "preload"},
+	&cli.BoolFlag{Name: "json", Usage: "print validation results as JSON"},
+	&cli.StringFlag{Name: "save", Usage: "write validation results as JSON to this path, for use as a --baseline"},
+	validationBaselineFlag,
+	networkFlag,
+	totalSupplyFlag,
+}
+
 var infoCmd = &cli.Command{
 	Name:        "info",
 	Description: "report blockchain and state info",
@@ -94,6 +245,69 @@ var infoCmd = &cli.Command{
 			Description: "Measure the sizes of all singleton actor HAMTs",
 			Action:      runHAMTSizeCmd,
 		},
+		{
+			Name:        "collections",
+			Description: "measure the structure and size of every HAMT and AMT in the state tree",
+			Action:      runInfoCollectionsCmd,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{Name: "json", Usage: "print the measurements as JSON"},
+			},
+		},
+		{
+			Name:        "what-if",
+			Description: "rebuild an actor's HAMT or AMT with other bitwidths and compare the layouts",
+			Action:      runInfoWhatIfCmd,
+			Flags: []cli.Flag{
+				&cli.IntSliceFlag{Name: "bitwidths", Usage: "bitwidths, from 1 to 8, to lay the collection out with", Value: cli.NewIntSlice(3, 4, 5, 6, 7, 8)},
+				&cli.BoolFlag{Name: "json", Usage: "print the layouts as JSON"},
+			},
+		},
+		{
+			Name:        "size",
+			Description: "attribute every block of the state tree to an actor type, actor and state field",
+			Action:      runInfoSizeCmd,
+			Flags: []cli.Flag{
+				&cli.IntFlag{Name: "top", Usage: "number of largest actors to list", Value: 20},
+				&cli.BoolFlag{Name: "json", Usage: "print the report as JSON"},
+			},
+		},
+		{
+			Name:        "churn",
+			Description: "count the blocks and bytes each state root in an epoch range adds, shares and drops relative to the previous one, by actor type",
+			Action:      runInfoChurnCmd,
+			Flags: []cli.Flag{
+				&cli.Int64Flag{Name: "from", Usage: "lowest epoch whose state is compared with the previous one"},
+				&cli.Int64Flag{Name: "to", Usage: "highest epoch to compare, defaults to the chain head", Value: math.MaxInt64},
+				&cli.StringFlag{Name: "preload"},
+				&cli.BoolFlag{Name: "json", Usage: "print the churn of every state and the summary as JSON"},
+			},
+		},
+		{
+			Name:        "actor",
+			Description: "print an actor and its decoded state as JSON",
+			Action:      runInfoActorCmd,
+			Flags: []cli.Flag{
+				&cli.IntFlag{Name: "depth", Usage: "expand HAMTs, AMTs and linked objects in the state to this many levels of nesting"},
+			},
+		},
+		{
+			Name:        "miner",
+			Description: "summarize a storage miner's info, balances, vesting and sectors by deadline",
+			Action:      runInfoMinerCmd,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{Name: "json", Usage: "print the summary as JSON"},
+			},
+		},
+	},
+}
+
+var diffCmd = &cli.Command{
+	Name:        "diff",
+	Description: "list the actors added, removed and changed between two state roots and diff the fields of changed actor states",
+	Action:      runDiffCmd,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{Name: "actors-only", Usage: "list changed actors without diffing their states"},
+		&cli.BoolFlag{Name: "migration", Usage: "diff a v0 state with its v2 migration output, showing only changes the migration is not expected to make"},
 	},
 }
 
@@ -123,12 +337,30 @@ func main() {
 				Name:  "cpuprofile",
 				Usage: "run cpuprofile and write results to provided file path",
 			},
+			&cli.StringFlag{
+				Name:  "memprofile",
+				Usage: "write a heap profile taken at the end of the command to provided file path",
+			},
+			&cli.StringFlag{
+				Name:  "allocs",
+				Usage: "write a profile of all allocations made during the command to provided file path",
+			},
+			&cli.StringFlag{
+				Name:  "trace",
+				Usage: "run an execution trace and write results to provided file path",
+			},
+			&cli.StringFlag{
+				Name:  "blockprofile",
+				Usage: "profile goroutine blocking and write results to provided file path",
+			},
 		},
 		Commands: []*cli.Command{
 			migrateCmd,
 			validateCmd,
 			infoCmd,
 			exportCmd,
+			benchCmd,
+			diffCmd,
 		},
 	}
 	sort.Sort(cli.CommandsByName(app.Commands))
@@ -145,7 +377,7 @@ func runMigrateOneCmd(c *cli.Context) error {
 	if c.Args().Len() != 2 {
 		return xerrors.Errorf("not enough args, need state root to migrate and height of state")
 	}
-	cleanUp, err := cpuProfile(c)
+	cleanUp, err := startProfiles(c)
 	if err != nil {
 		return err
 	}
@@ -159,6 +391,10 @@ func runMigrateOneCmd(c *cli.Context) error {
 		return err
 	}
 	height := abi.ChainEpoch(int64(hRaw))
+	mig, err := lib.GetMigration(c.String("migration"))
+	if err != nil {
+		return err
+	}
 	chn := lib.Chain{}
 
 	preloadStr := c.String("preload")
@@ -169,13 +405,51 @@ func runMigrateOneCmd(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	stateRootIn, err = lib.LoadActorsRoot(c.Context, store, stateRootIn)
+	if err != nil {
+		return err
+	}
+	if err := mig.CheckInput(c.Context, store, stateRootIn); err != nil {
+		return xerrors.Errorf("cannot run migration %s: %w", mig.Name, err)
+	}
+	vr, err := newValidationReporter(c)
+	if err != nil {
+		return err
+	}
+	if err := vr.useNetwork(c.Context, store, stateRootIn, c.Bool("validate") || c.Bool("pre-validate")); err != nil {
+		return err
+	}
+	if c.Bool("pre-validate") {
+		if err := vr.preValidateMigration(c.Context, store, mig, height, stateRootIn); err != nil {
+			return err
+		}
+	}
+	reporter, err := newMigrationReporter(c, mig)
+	if err != nil {
+		return err
+	}
+	migStore, err := reporter.wrapStore(c.Context, store, stateRootIn)
+	if err != nil {
+		return err
+	}
+	sampler := lib.StartMemSampler(memSampleInterval)
 	start := time.Now()
-	stateRootOut, err := migration2.MigrateStateTree(c.Context, store, stateRootIn, height, migration2.DefaultConfig())
+	stateRootOut, err := reporter.migrate(c.Context, migStore, mig, stateRootIn, height)
 	duration := time.Since(start)
+	mem := sampler.Stop()
 	if err != nil {
 		return err
 	}
-	fmt.Printf("%s => %s -- %v\n", stateRootIn, stateRootOut, duration)
+	if err := mig.CheckOutput(c.Context, store, stateRootOut); err != nil {
+		return xerrors.Errorf("migration %s gave an unexpected output: %w", mig.Name, err)
+	}
+	fmt.Printf("%s => %s -- %v -- %s\n", stateRootIn, stateRootOut, duration, formatMemStats(mem))
+	if err := reporter.report(c.Context, store, mig, stateRootIn, stateRootOut, height, duration); err != nil {
+		return err
+	}
+	if err := reporter.save(); err != nil {
+		return err
+	}
 
 	// Measure flush time
 	writeStart := time.Now()
@@ -186,20 +460,25 @@ func runMigrateOneCmd(c *cli.Context) error {
 	fmt.Printf("%s buffer flush time: %v\n", stateRootOut, writeDuration)
 
 	if c.Bool("validate") {
-		err := validate(c.Context, store, height, stateRootOut)
+		err := vr.validateMigration(c.Context, store, mig, height, stateRootOut)
 		if err != nil {
 			return err
 		}
 	}
+	if c.Bool("audit") {
+		if err := audit(c.Context, store, stateRootIn, stateRootOut); err != nil {
+			return err
+		}
+	}
 
-	return nil
+	return vr.err()
 }
 
 func runMigrateChainCmd(c *cli.Context) error {
 	if !c.Args().Present() {
 		return xerrors.Errorf("not enough args, need chain head to migrate")
 	}
-	cleanUp, err := cpuProfile(c)
+	cleanUp, err := startProfiles(c)
 	if err != nil {
 		return err
 	}
@@ -208,6 +487,10 @@ func runMigrateChainCmd(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	mig, err := lib.GetMigration(c.String("migration"))
+	if err != nil {
+		return err
+	}
 	chn := lib.Chain{}
 
 	preloadStr := c.String("preload")
@@ -221,18 +504,57 @@ func runMigrateChainCmd(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	reporter, err := newMigrationReporter(c, mig)
+	if err != nil {
+		return err
+	}
+	vr, err := newValidationReporter(c)
+	if err != nil {
+		return err
+	}
 	k := c.Int("skip")
 	for !iter.Done() {
 		val := iter.Val()
 		if k == 0 || val.Height%int64(k) == int64(0) { // skip every k epochs
-			start := time.Now()
 			height := abi.ChainEpoch(val.Height)
-			stateRootOut, err := migration2.MigrateStateTree(c.Context, store, val.State, height, migration2.DefaultConfig())
+			actorsRootIn, err := lib.LoadActorsRoot(c.Context, store, val.State)
+			if err != nil {
+				return err
+			}
+			if err := mig.CheckInput(c.Context, store, actorsRootIn); err != nil {
+				fmt.Printf("%d -- %s !! %v\n", val.Height, val.State, err)
+				if err := iter.Step(c.Context); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := vr.useNetwork(c.Context, store, actorsRootIn, c.Bool("validate") || c.Bool("pre-validate")); err != nil {
+				return err
+			}
+			if c.Bool("pre-validate") {
+				if err := vr.preValidateMigration(c.Context, store, mig, height, actorsRootIn); err != nil {
+					return err
+				}
+			}
+			migStore, err := reporter.wrapStore(c.Context, store, actorsRootIn)
+			if err != nil {
+				return err
+			}
+			sampler := lib.StartMemSampler(memSampleInterval)
+			start := time.Now()
+			stateRootOut, err := reporter.migrate(c.Context, migStore, mig, actorsRootIn, height)
 			duration := time.Since(start)
+			mem := sampler.Stop()
+			if err == nil {
+				err = mig.CheckOutput(c.Context, store, stateRootOut)
+			}
 			if err != nil {
 				fmt.Printf("%d -- %s => %s !! %v\n", val.Height, val.State, stateRootOut, err)
 			} else {
-				fmt.Printf("%d -- %s => %s -- %v\n", val.Height, val.State, stateRootOut, duration)
+				fmt.Printf("%d -- %s => %s -- %v -- %s\n", val.Height, val.State, stateRootOut, duration, formatMemStats(mem))
+				if err := reporter.report(c.Context, store, mig, actorsRootIn, stateRootOut, height, duration); err != nil {
+					return err
+				}
 			}
 			writeStart := time.Now()
 			if err := chn.FlushBufferedState(c.Context, stateRootOut); err != nil {
@@ -243,166 +565,1185 @@ func runMigrateChainCmd(c *cli.Context) error {
 
 			// Optional Post-Migration State Validation
 			if c.Bool("validate") {
-				err := validate(c.Context, store, height, stateRootOut)
+				err := vr.validateMigration(c.Context, store, mig, height, stateRootOut)
 				if err != nil {
 					return err
 				}
 			}
+			if c.Bool("audit") {
+				if err := audit(c.Context, store, actorsRootIn, stateRootOut); err != nil {
+					return err
+				}
+			}
 		}
 
 		if err := iter.Step(c.Context); err != nil {
 			return err
 		}
 	}
-	return nil
+	if err := reporter.save(); err != nil {
+		return err
+	}
+	return vr.err()
 }
 
-func runValidateCmd(c *cli.Context) error {
-	if c.Args().Len() != 2 {
-		return xerrors.Errorf("wrong numberof args, need state root to migrate and height")
-	}
-	cleanUp, err := cpuProfile(c)
+func runMigrateCheckCmd(c *cli.Context) error {
+	cleanUp, err := startProfiles(c)
 	if err != nil {
 		return err
 	}
 	defer cleanUp()
-
-	stateRoot, err := cid.Decode(c.Args().First())
+	mig, err := lib.GetMigration(c.String("migration"))
 	if err != nil {
 		return err
 	}
-	hRaw, err := strconv.Atoi(c.Args().Get(1))
+	goldenPath := c.String("golden")
+	entries, err := lib.LoadGoldenFile(goldenPath)
 	if err != nil {
 		return err
 	}
-	height := abi.ChainEpoch(int64(hRaw))
 	chn := lib.Chain{}
 	store, err := chn.LoadCborStore(c.Context)
 	if err != nil {
 		return err
 	}
 
-	return validate(c.Context, store, height, stateRoot)
-}
+	mismatches := 0
+	for i, entry := range entries {
+		// Drop the previous entry's output so memory use doesn't grow with the
+		// golden file
+		if err := chn.DiscardBufferedState(c.Context); err != nil {
+			return err
+		}
+		actorsRootIn, err := lib.LoadActorsRoot(c.Context, store, entry.Input)
+		if err != nil {
+			return err
+		}
+		if err := mig.CheckInput(c.Context, store, actorsRootIn); err != nil {
+			return xerrors.Errorf("cannot run migration %s on %s: %w", mig.Name, entry.Input, err)
+		}
+		start := time.Now()
+		stateRootOut, err := mig.Migrate(c.Context, store, actorsRootIn, entry.Epoch)
+		duration := time.Since(start)
+		if err != nil {
+			return xerrors.Errorf("failed to migrate %s at epoch %d: %w", entry.Input, entry.Epoch, err)
+		}
+		if err := mig.CheckOutput(c.Context, store, stateRootOut); err != nil {
+			return xerrors.Errorf("migration %s of %s gave an unexpected output: %w", mig.Name, entry.Input, err)
+		}
+		if c.Bool("update") {
+			// Keep the expected tree so later mismatches can be diffed against it
+			if err := chn.FlushBufferedState(c.Context, stateRootOut); err != nil {
+				return xerrors.Errorf("failed to flush state tree to disk: %w", err)
+			}
+			fmt.Printf("%d -- %s => %s -- %v\n", entry.Epoch, entry.Input, stateRootOut, duration)
+			entries[i].Output = stateRootOut
+			continue
+		}
+		if stateRootOut.Equals(entry.Output) {
+			fmt.Printf("%d -- %s => %s -- ok -- %v\n", entry.Epoch, entry.Input, stateRootOut, duration)
+			continue
+		}
+		fmt.Printf("%d -- %s => %s -- MISMATCH expected %s -- %v\n", entry.Epoch, entry.Input, stateRootOut, entry.Output, duration)
+		mismatches++
+		if mismatches == 1 {
+			err := printActorDiffs(c.Context, store, entry.Output, stateRootOut)
+			if xerrors.Is(err, blockstore.ErrNotFound) {
+				fmt.Printf("expected state %s is not in the store, no per-actor diff available, run with --update to store the outputs\n", entry.Output)
+			} else if err != nil {
+				return xerrors.Errorf("failed to diff %s against %s: %w", entry.Output, stateRootOut, err)
+			}
+		}
+	}
 
-func runRootsCmd(c *cli.Context) error {
-	if c.Args().Len() < 2 {
-		return xerrors.Errorf("not enough args, need chain tip and number of states to fetch")
+	if c.Bool("update") {
+		return lib.WriteGoldenFile(goldenPath, entries)
 	}
+	if mismatches > 0 {
+		return xerrors.Errorf("%d of %d migrations did not match golden outputs", mismatches, len(entries))
+	}
+	return nil
+}
 
+func runMigrateBisectCmd(c *cli.Context) error {
+	if !c.Args().Present() {
+		return xerrors.Errorf("not enough args, need chain head to search from")
+	}
+	from, to := c.Int64("from"), c.Int64("to")
+	if from > to {
+		return xerrors.Errorf("empty range, --from %d is above --to %d", from, to)
+	}
+	cleanUp, err := startProfiles(c)
+	if err != nil {
+		return err
+	}
+	defer cleanUp()
 	bcid, err := cid.Decode(c.Args().First())
 	if err != nil {
 		return err
 	}
-	num, err := strconv.Atoi(c.Args().Get(1))
+	mig, err := lib.GetMigration(c.String("migration"))
 	if err != nil {
 		return err
 	}
-	// Read roots and epoch of creation from lotus datastore
-	roots := make([]lib.IterVal, num)
 	chn := lib.Chain{}
+	store, err := chn.LoadCborStore(c.Context)
+	if err != nil {
+		return err
+	}
+
+	// Collect the states in range, the iterator walks from head to genesis
 	iter, err := chn.NewChainStateIterator(c.Context, bcid)
 	if err != nil {
 		return err
 	}
-	for i := 0; !iter.Done() && i < num; i++ {
-		roots[i] = iter.Val()
+	var states []lib.IterVal
+	for !iter.Done() {
+		val := iter.Val()
+		if val.Height < from {
+			break
+		}
+		if val.Height <= to {
+			states = append(states, val)
+		}
 		if err := iter.Step(c.Context); err != nil {
 			return err
 		}
 	}
-	// Output roots
-	for _, val := range roots {
-		fmt.Printf("Epoch %d: %s \n", val.Height, val.State)
+	if len(states) == 0 {
+		return xerrors.Errorf("no states between epochs %d and %d", from, to)
 	}
-	return nil
-}
-
-func runDebtsCmd(c *cli.Context) error {
-	if !c.Args().Present() {
-		return xerrors.Errorf("not enough args, need state root")
+	for i, j := 0, len(states)-1; i < j; i, j = i+1, j-1 {
+		states[i], states[j] = states[j], states[i]
 	}
-	stateRootIn, err := cid.Decode(c.Args().First())
+
+	// Policy is global so resolve the network once, from the earliest state,
+	// before the first migration
+	firstRoot, err := lib.LoadActorsRoot(c.Context, store, states[0].State)
 	if err != nil {
 		return err
 	}
-	chn := lib.Chain{}
-	store, err := chn.LoadCborStore(c.Context)
-	if err != nil {
+	vr := &validationReporter{networkName: c.String("network"), totalSupply: c.String("total-supply")}
+	if err := vr.useNetwork(c.Context, store, firstRoot, c.Bool("validate") && mig.Validate != nil); err != nil {
 		return err
 	}
 
-	bf, err := migration2.InputTreeBurntFunds(c.Context, store, stateRootIn)
-	if err != nil {
-		return err
+	failures := make(map[int]string)
+	probe := func(i int) (bool, error) {
+		val := states[i]
+		failure, err := bisectProbe(c, &chn, store, mig, vr.network, val)
+		if err != nil {
+			return false, err
+		}
+		if failure != "" {
+			fmt.Printf("%d -- %s -- fail\n", val.Height, val.State)
+			failures[i] = failure
+			return true, nil
+		}
+		fmt.Printf("%d -- %s -- ok\n", val.Height, val.State)
+		return false, nil
 	}
 
-	available, err := migration2.InputTreeMinerAvailableBalance(c.Context, store, stateRootIn)
+	// Assumes that once migrations start failing they keep failing
+	lastFails, err := probe(len(states) - 1)
 	if err != nil {
 		return err
 	}
-	// filter out positive balances
-	totalDebt := big.Zero()
-	for addr, balance := range available {
-		if balance.LessThan(big.Zero()) {
-			debt := balance.Neg()
-			fmt.Printf("miner %s: %s\n", addr, debt)
-			totalDebt = big.Add(totalDebt, debt)
+	if !lastFails {
+		fmt.Printf("no failure found, state at epoch %d migrates cleanly\n", states[len(states)-1].Height)
+		return nil
+	}
+	lo, hi := 0, len(states)-1 // states[hi] is known to fail
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		fails, err := probe(mid)
+		if err != nil {
+			return err
+		}
+		if fails {
+			hi = mid
+		} else {
+			lo = mid + 1
 		}
 	}
-	fmt.Printf("burnt funds balance: %s\n", bf)
-	fmt.Printf("total debt:          %s\n", totalDebt)
+
+	first := states[hi]
+	fmt.Printf("first failing epoch: %d\nstate root: %s\nfailure: %s\n", first.Height, first.State, failures[hi])
 	return nil
 }
 
-func runBalancesCmd(c *cli.Context) error {
-	if !c.Args().Present() {
-		return xerrors.Errorf("not enough args, need state root")
+// bisectProbe migrates one state and describes why it failed, or returns the
+// empty string if it did not.
+func bisectProbe(c *cli.Context, chn *lib.Chain, store cbornode.IpldStore, mig lib.Migration, network *lib.Network, val lib.IterVal) (string, error) {
+	// Drop the previous probe's output so memory use doesn't grow with the search
+	if err := chn.DiscardBufferedState(c.Context); err != nil {
+		return "", err
 	}
-	stateRootIn, err := cid.Decode(c.Args().First())
+	height := abi.ChainEpoch(val.Height)
+	actorsRootIn, err := lib.LoadActorsRoot(c.Context, store, val.State)
 	if err != nil {
-		return err
+		return "", err
 	}
-	chn := lib.Chain{}
-	store, err := chn.LoadCborStore(c.Context)
+	if err := mig.CheckInput(c.Context, store, actorsRootIn); err != nil {
+		return "", xerrors.Errorf("cannot run migration %s on %s: %w", mig.Name, val.State, err)
+	}
+	stateRootOut, err := mig.Migrate(c.Context, store, actorsRootIn, height)
 	if err != nil {
-		return err
+		return fmt.Sprintf("migration error: %v", err), nil
 	}
-
-	balances, err := lib.V0TreeMinerBalances(c.Context, store, stateRootIn)
+	if err := mig.CheckOutput(c.Context, store, stateRootOut); err != nil {
+		return fmt.Sprintf("migration output error: %v", err), nil
+	}
+	if !c.Bool("validate") || mig.Validate == nil {
+		return "", nil
+	}
+	acc, err := mig.Validate(c.Context, store, stateRootOut, height, *network)
 	if err != nil {
-		return err
+		return fmt.Sprintf("validation error on %s: %v", stateRootOut, err), nil
 	}
-	// Print miner address, locked balance, and available balance (balance - lb - pcd - ip)
-	for addr, bi := range balances {
-		minerLiabilities := big.Sum(bi.LockedFunds, bi.PreCommitDeposits, bi.InitialPledge)
-		availableBalance := big.Sub(bi.Balance, minerLiabilities)
-		fmt.Printf("%s,%v,%v\n", addr, bi.LockedFunds, availableBalance)
+	if !acc.IsEmpty() {
+		return fmt.Sprintf("validation of %s reported errors:\n%s", stateRootOut, strings.Join(acc.Messages(), "\n")), nil
+	}
+	return "", nil
+}
+
+func runMigrateListCmd(c *cli.Context) error {
+	for _, name := range lib.MigrationNames() {
+		mig, err := lib.GetMigration(name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: actors %s => %s\n", mig.Name, mig.InputVersion, mig.OutputVersion)
 	}
 	return nil
 }
 
-func runHAMTSizeCmd(c *cli.Context) error {
-	if !c.Args().Present() {
-		return xerrors.Errorf("not enough args, need state root")
+func runMigrateActorCmd(c *cli.Context) error {
+	if c.Args().Len() != 3 {
+		return xerrors.Errorf("wrong number of args, need state root, height of state and actor address")
+	}
+	cleanUp, err := startProfiles(c)
+	if err != nil {
+		return err
 	}
+	defer cleanUp()
 	stateRootIn, err := cid.Decode(c.Args().First())
 	if err != nil {
 		return err
 	}
-	chn := lib.Chain{}
-	store, err := chn.LoadCborStore(c.Context)
+	hRaw, err := strconv.Atoi(c.Args().Get(1))
 	if err != nil {
 		return err
 	}
-	tree, err := loadStateTree(c.Context, store, stateRootIn)
+	height := abi.ChainEpoch(int64(hRaw))
+	addr, err := address.NewFromString(c.Args().Get(2))
 	if err != nil {
 		return err
 	}
-	return lib.PrintHAMTSizes(c.Context, store, tree)
-}
-
-func runExportSectorsCmd(c *cli.Context) error {
+	mig, err := lib.GetMigration(c.String("migration"))
+	if err != nil {
+		return err
+	}
+	if mig.MigrateActor == nil {
+		return xerrors.Errorf("migration %s cannot migrate a single actor", mig.Name)
+	}
+	chn := lib.Chain{}
+
+	preloadStr := c.String("preload")
+	maybePreload(c.Context, &chn, preloadStr)
+
+	store, err := chn.LoadCborStore(c.Context)
+	if err != nil {
+		return err
+	}
+	stateRootIn, err = lib.LoadActorsRoot(c.Context, store, stateRootIn)
+	if err != nil {
+		return err
+	}
+	if err := mig.CheckInput(c.Context, store, stateRootIn); err != nil {
+		return xerrors.Errorf("cannot run migration %s: %w", mig.Name, err)
+	}
+	actorIn, err := lib.LoadActor(c.Context, store, stateRootIn, addr)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	stateRootOut, err := mig.MigrateActor(c.Context, store, stateRootIn, height, addr)
+	duration := time.Since(start)
+	if err != nil {
+		return err
+	}
+	actorOut, err := lib.LoadActor(c.Context, store, stateRootOut, addr)
+	if err != nil {
+		return xerrors.Errorf("migrated actor missing from output: %w", err)
+	}
+	fmt.Printf("%s: %s => %s -- %v\n", addr, actorIn.Head, actorOut.Head, duration)
+
+	fmt.Printf("input:\n")
+	if err := printActorJSON(c.Context, store, addr, actorIn, 0); err != nil {
+		return err
+	}
+	fmt.Printf("output:\n")
+	if err := printActorJSON(c.Context, store, addr, actorOut, 0); err != nil {
+		return err
+	}
+
+	if mig.OutputVersion != lib.ActorsVersion2 {
+		fmt.Printf("Validation: %s -- skipped, no invariant checks for actors %s\n", addr, mig.OutputVersion)
+		return nil
+	}
+	checkStart := time.Now()
+	acc, err := lib.CheckActorInvariants(adt0.WrapStore(c.Context, store), addr, actorOut, height)
+	checkDuration := time.Since(checkStart)
+	if err != nil {
+		return xerrors.Errorf("failed to check actor invariants: %w", err)
+	}
+	if acc.IsEmpty() {
+		fmt.Printf("Validation: %s -- no errors -- %v\n", addr, checkDuration)
+	} else {
+		fmt.Printf("Validation: %s -- with errors -- %v\n%s\n", addr, checkDuration, strings.Join(acc.Messages(), "\n"))
+	}
+	return nil
+}
+
+func runValidateV2Cmd(c *cli.Context) error {
+	sel, err := checkSelection(c)
+	if err != nil {
+		return err
+	}
+	var estimateFrom []*lib.ValidationResult
+	if path := c.String("estimate-from"); path != "" {
+		estimateFrom, err = lib.LoadValidationResults(path)
+		if err != nil {
+			return err
+		}
+	}
+	cleanUp, err := startProfiles(c)
+	if err != nil {
+		return err
+	}
+	defer cleanUp()
+	store, stateRoot, actorsRoot, height, err := loadValidateArgs(c, lib.ActorsVersion2)
+	if err != nil {
+		return err
+	}
+	network, err := resolveNetwork(c.Context, store, actorsRoot, c.String("network"), c.String("total-supply"))
+	if err != nil {
+		return err
+	}
+	network.ApplyPolicy()
+	vr, err := newValidationReporter(c)
+	if err != nil {
+		return err
+	}
+
+	result, err := lib.Validate(c.Context, store, stateRoot, height, lib.ValidateOptions{
+		Network:   &network,
+		Selection: sel,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := vr.report("Validation", result); err != nil {
+		return err
+	}
+	if !vr.json && !sel.All() {
+		printSelectiveChecks(result, estimateFrom)
+	}
+	return vr.err()
+}
+
+// checkSelection builds the selection of actors to check from --only and
+// --actor.
+func checkSelection(c *cli.Context) (lib.CheckSelection, error) {
+	var types []string
+	for _, t := range c.StringSlice("only") {
+		for _, name := range strings.Split(t, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				types = append(types, name)
+			}
+		}
+	}
+	var addrs []address.Address
+	for _, raw := range c.StringSlice("actor") {
+		addr, err := address.NewFromString(raw)
+		if err != nil {
+			return lib.CheckSelection{}, xerrors.Errorf("failed to parse actor address %s: %w", raw, err)
+		}
+		addrs = append(addrs, addr)
+	}
+	return lib.NewCheckSelection(types, addrs)
+}
+
+// printSelectiveChecks prints how many actors of each type were checked and
+// skipped and estimates the time the skipped checks would have taken from
+// the mean check time of each type.  Means come from estimateFrom if it
+// holds a full validation with per-type check times, and otherwise from the
+// actors checked in this run.
+func printSelectiveChecks(result *lib.ValidationResult, estimateFrom []*lib.ValidationResult) {
+	var full *lib.ValidationResult
+	for _, r := range estimateFrom {
+		if len(r.Checks) > 0 && (full == nil || r.StateRoot == result.StateRoot) {
+			full = r
+		}
+	}
+	source := "from the actors checked in this run"
+	checks := result.Checks
+	if full != nil {
+		source = fmt.Sprintf("from full validation of %s taking %v", full.StateRoot, full.Duration)
+		checks = full.Checks
+	}
+	meanDurations := make(map[string]time.Duration)
+	for _, tc := range checks {
+		if tc.Checked > 0 {
+			meanDurations[tc.Type] = tc.Duration / time.Duration(tc.Checked)
+		}
+	}
+
+	checked, skipped := 0, 0
+	var saved time.Duration
+	var untimed []string
+	fmt.Printf("    %-10s %10s %10s %14s\n", "type", "checked", "skipped", "check time")
+	for _, tc := range result.Checks {
+		fmt.Printf("    %-10s %10d %10d %14v\n", tc.Type, tc.Checked, tc.Skipped, tc.Duration)
+		checked += tc.Checked
+		skipped += tc.Skipped
+		if tc.Skipped == 0 {
+			continue
+		}
+		mean, found := meanDurations[tc.Type]
+		if !found {
+			untimed = append(untimed, tc.Type)
+		}
+		saved += mean * time.Duration(tc.Skipped)
+	}
+	fmt.Printf("checked %d actors, skipped %d -- est. time saved: %v (%s)\n", checked, skipped, saved, source)
+	if len(untimed) > 0 {
+		fmt.Printf("no check times to estimate skipped %s actors from, they are left out of the estimate -- pass --estimate-from a full validation saved with --save to include them\n", strings.Join(untimed, ", "))
+	}
+}
+
+func runValidateV0Cmd(c *cli.Context) error {
+	cleanUp, err := startProfiles(c)
+	if err != nil {
+		return err
+	}
+	defer cleanUp()
+	store, stateRoot, actorsRoot, height, err := loadValidateArgs(c, lib.ActorsVersion0)
+	if err != nil {
+		return err
+	}
+	network, err := resolveNetwork(c.Context, store, actorsRoot, c.String("network"), c.String("total-supply"))
+	if err != nil {
+		return err
+	}
+	network.ApplyPolicy()
+	vr, err := newValidationReporter(c)
+	if err != nil {
+		return err
+	}
+	result, err := lib.Validate(c.Context, store, stateRoot, height, lib.ValidateOptions{Network: &network})
+	if err != nil {
+		return err
+	}
+	if _, err := vr.report("Validation", result); err != nil {
+		return err
+	}
+	return vr.err()
+}
+
+// loadValidateArgs decodes the state root and height arguments of the
+// validate commands, loads the state's actors root and checks its actors
+// version.
+func loadValidateArgs(c *cli.Context, version lib.ActorsVersion) (store cbornode.IpldStore, stateRoot, actorsRoot cid.Cid, height abi.ChainEpoch, err error) {
+	if c.Args().Len() != 2 {
+		return nil, cid.Undef, cid.Undef, 0, xerrors.Errorf("wrong numberof args, need state root to migrate and height")
+	}
+	stateRoot, err = cid.Decode(c.Args().First())
+	if err != nil {
+		return nil, cid.Undef, cid.Undef, 0, err
+	}
+	hRaw, err := strconv.Atoi(c.Args().Get(1))
+	if err != nil {
+		return nil, cid.Undef, cid.Undef, 0, err
+	}
+	height = abi.ChainEpoch(int64(hRaw))
+	chn := lib.Chain{}
+	store, err = chn.LoadCborStore(c.Context)
+	if err != nil {
+		return nil, cid.Undef, cid.Undef, 0, err
+	}
+	actorsRoot, err = lib.LoadActorsRoot(c.Context, store, stateRoot)
+	if err != nil {
+		return nil, cid.Undef, cid.Undef, 0, err
+	}
+	if err := checkStateVersion(c.Context, store, actorsRoot, version); err != nil {
+		return nil, cid.Undef, cid.Undef, 0, err
+	}
+	return store, stateRoot, actorsRoot, height, nil
+}
+
+func runValidateChainCmd(c *cli.Context) error {
+	if !c.Args().Present() {
+		return xerrors.Errorf("not enough args, need chain head to validate from")
+	}
+	from, to := c.Int64("from"), c.Int64("to")
+	if from > to {
+		return xerrors.Errorf("empty range, --from %d is above --to %d", from, to)
+	}
+	workers := c.Int("workers")
+	if workers < 1 {
+		return xerrors.Errorf("need at least one worker")
+	}
+	cleanUp, err := startProfiles(c)
+	if err != nil {
+		return err
+	}
+	defer cleanUp()
+	bcid, err := cid.Decode(c.Args().First())
+	if err != nil {
+		return err
+	}
+	chn := lib.Chain{}
+	preloadStr := c.String("preload")
+	maybePreload(c.Context, &chn, preloadStr)
+	store, err := chn.LoadCborStore(c.Context)
+	if err != nil {
+		return err
+	}
+
+	// Collect the states in range, the iterator walks from head to genesis
+	iter, err := chn.NewChainStateIterator(c.Context, bcid)
+	if err != nil {
+		return err
+	}
+	k := int64(c.Int("skip"))
+	var states []lib.IterVal
+	for !iter.Done() {
+		val := iter.Val()
+		if val.Height < from {
+			break
+		}
+		if val.Height <= to && (k == 0 || val.Height%k == 0) {
+			states = append(states, val)
+		}
+		if err := iter.Step(c.Context); err != nil {
+			return err
+		}
+	}
+	if len(states) == 0 {
+		return xerrors.Errorf("no states between epochs %d and %d", from, to)
+	}
+	for i, j := 0, len(states)-1; i < j; i, j = i+1, j-1 {
+		states[i], states[j] = states[j], states[i]
+	}
+
+	// Policy is global so resolve the network once, from the latest state,
+	// before validating concurrently
+	latestRoot, err := lib.LoadActorsRoot(c.Context, store, states[len(states)-1].State)
+	if err != nil {
+		return err
+	}
+	network, err := resolveNetwork(c.Context, store, latestRoot, c.String("network"), c.String("total-supply"))
+	if err != nil {
+		return err
+	}
+	network.ApplyPolicy()
+	vr, err := newValidationReporter(c)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("validating %d states between epochs %d and %d with %d workers\n", len(states), states[0].Height, states[len(states)-1].Height, workers)
+	results := make([]*lib.ValidationResult, len(states))
+	errs := make([]error, len(states))
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				results[i], errs[i] = validateChainState(c.Context, store, states[i], network)
+			}
+		}()
+	}
+	for i := range states {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+
+	// Report in epoch order and remember where each invariant first fails
+	type firstFailure struct {
+		epoch  int64
+		states int
+	}
+	firstFailures := make(map[string]*firstFailure)
+	var invariants []string
+	for i, val := range states {
+		if errs[i] != nil {
+			return xerrors.Errorf("failed to validate state %s at epoch %d: %w", val.State, val.Height, errs[i])
+		}
+		violations, err := vr.report("Validation", results[i])
+		if err != nil {
+			return err
+		}
+		seen := make(map[string]struct{})
+		for _, v := range violations {
+			invariant := v.Invariant()
+			if _, ok := seen[invariant]; ok {
+				continue
+			}
+			seen[invariant] = struct{}{}
+			ff, ok := firstFailures[invariant]
+			if !ok {
+				ff = &firstFailure{epoch: val.Height}
+				firstFailures[invariant] = ff
+				invariants = append(invariants, invariant)
+			}
+			ff.states++
+		}
+	}
+	if !vr.json && len(invariants) > 0 {
+		fmt.Printf("first failing epoch of each invariant:\n")
+		for _, invariant := range invariants {
+			ff := firstFailures[invariant]
+			fmt.Printf("    %d -- failing in %d/%d states -- %s\n", ff.epoch, ff.states, len(states), invariant)
+		}
+	}
+	return vr.err()
+}
+
+// validateChainState checks the invariants of one state from the chain with
+// the checks of its actors version.
+func validateChainState(ctx context.Context, store cbornode.IpldStore, val lib.IterVal, network lib.Network) (*lib.ValidationResult, error) {
+	return lib.Validate(ctx, store, val.State, abi.ChainEpoch(val.Height), lib.ValidateOptions{Network: &network})
+}
+
+func runValidateAuditCmd(c *cli.Context) error {
+	if c.Args().Len() != 2 {
+		return xerrors.Errorf("wrong number of args, need migration input and output state roots")
+	}
+	cleanUp, err := startProfiles(c)
+	if err != nil {
+		return err
+	}
+	defer cleanUp()
+
+	stateRootIn, err := cid.Decode(c.Args().First())
+	if err != nil {
+		return err
+	}
+	stateRootOut, err := cid.Decode(c.Args().Get(1))
+	if err != nil {
+		return err
+	}
+	chn := lib.Chain{}
+	store, err := chn.LoadCborStore(c.Context)
+	if err != nil {
+		return err
+	}
+	return audit(c.Context, store, stateRootIn, stateRootOut)
+}
+
+func runRootsCmd(c *cli.Context) error {
+	if c.Args().Len() < 2 {
+		return xerrors.Errorf("not enough args, need chain tip and number of states to fetch")
+	}
+
+	bcid, err := cid.Decode(c.Args().First())
+	if err != nil {
+		return err
+	}
+	num, err := strconv.Atoi(c.Args().Get(1))
+	if err != nil {
+		return err
+	}
+	// Read roots and epoch of creation from lotus datastore
+	roots := make([]lib.IterVal, num)
+	chn := lib.Chain{}
+	iter, err := chn.NewChainStateIterator(c.Context, bcid)
+	if err != nil {
+		return err
+	}
+	for i := 0; !iter.Done() && i < num; i++ {
+		roots[i] = iter.Val()
+		if err := iter.Step(c.Context); err != nil {
+			return err
+		}
+	}
+	// Output roots
+	for _, val := range roots {
+		fmt.Printf("Epoch %d: %s \n", val.Height, val.State)
+	}
+	return nil
+}
+
+func runDebtsCmd(c *cli.Context) error {
+	if !c.Args().Present() {
+		return xerrors.Errorf("not enough args, need state root")
+	}
+	stateRootIn, err := cid.Decode(c.Args().First())
+	if err != nil {
+		return err
+	}
+	chn := lib.Chain{}
+	store, err := chn.LoadCborStore(c.Context)
+	if err != nil {
+		return err
+	}
+
+	actorsRoot, err := lib.LoadActorsRoot(c.Context, store, stateRootIn)
+	if err != nil {
+		return err
+	}
+	bf, debts, err := lib.TreeMinerDebts(c.Context, store, actorsRoot)
+	if err != nil {
+		return err
+	}
+	totalDebt := big.Zero()
+	for addr, debt := range debts {
+		fmt.Printf("miner %s: %s\n", addr, debt)
+		totalDebt = big.Add(totalDebt, debt)
+	}
+	fmt.Printf("burnt funds balance: %s\n", bf)
+	fmt.Printf("total debt:          %s\n", totalDebt)
+	return nil
+}
+
+func runBalancesCmd(c *cli.Context) error {
+	if !c.Args().Present() {
+		return xerrors.Errorf("not enough args, need state root")
+	}
+	stateRootIn, err := cid.Decode(c.Args().First())
+	if err != nil {
+		return err
+	}
+	chn := lib.Chain{}
+	store, err := chn.LoadCborStore(c.Context)
+	if err != nil {
+		return err
+	}
+
+	actorsRoot, err := lib.LoadActorsRoot(c.Context, store, stateRootIn)
+	if err != nil {
+		return err
+	}
+	balances, err := lib.TreeMinerBalances(c.Context, store, actorsRoot)
+	if err != nil {
+		return err
+	}
+	// Print miner address, locked balance, and available balance (balance - lb - pcd - ip - debt)
+	for addr, bi := range balances {
+		fmt.Printf("%s,%v,%v\n", addr, bi.LockedFunds, bi.Available)
+	}
+	return nil
+}
+
+func runHAMTSizeCmd(c *cli.Context) error {
+	if !c.Args().Present() {
+		return xerrors.Errorf("not enough args, need state root")
+	}
+	stateRootIn, err := cid.Decode(c.Args().First())
+	if err != nil {
+		return err
+	}
+	chn := lib.Chain{}
+	store, err := chn.LoadCborStore(c.Context)
+	if err != nil {
+		return err
+	}
+	tree, err := lib.LoadStateTree(c.Context, store, stateRootIn)
+	if err != nil {
+		return err
+	}
+	return lib.PrintHAMTSizes(c.Context, store, tree.Tree)
+}
+
+func runInfoCollectionsCmd(c *cli.Context) error {
+	if !c.Args().Present() {
+		return xerrors.Errorf("not enough args, need state root")
+	}
+	stateRootIn, err := cid.Decode(c.Args().First())
+	if err != nil {
+		return err
+	}
+	chn := lib.Chain{}
+	store, err := chn.LoadCborStore(c.Context)
+	if err != nil {
+		return err
+	}
+	actorsRoot, err := lib.LoadActorsRoot(c.Context, store, stateRootIn)
+	if err != nil {
+		return err
+	}
+	stats, err := lib.MeasureCollections(c.Context, store, actorsRoot)
+	if err != nil {
+		return err
+	}
+	if c.Bool("json") {
+		j, err := json.MarshalIndent(stats, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", j)
+		return nil
+	}
+	printCollectionStats(stats)
+	return nil
+}
+
+// printCollectionStats prints a CSV table of collection measurements
+// followed by their key and value size histograms.
+func printCollectionStats(stats []*lib.CollectionStats) {
+	fmt.Printf("name,kind,instances,entries,nodes,max depth,fanout use,bytes,mean path blocks,mean path bytes,mean key bytes,mean value bytes,max value bytes,largest actor,largest entries\n")
+	for _, s := range stats {
+		fmt.Printf("%s,%s,%d,%d,%d,%d,%.3f,%d,%.2f,%.0f,%.1f,%.1f,%d,%s,%d\n", s.Name, s.Kind, s.Instances, s.Entries, s.Nodes, s.Depth,
+			s.FanoutUse(), s.Bytes, s.MeanPathBlocks(), s.MeanPathBytes(), s.Keys.Mean(), s.Values.Mean(), s.Values.Max, s.Largest, s.LargestEntries)
+	}
+	fmt.Printf("\nsize histograms, bytes: count\n")
+	for _, s := range stats {
+		for _, h := range []struct {
+			name string
+			hist lib.SizeHistogram
+		}{{"keys", s.Keys}, {"values", s.Values}} {
+			if h.hist.Count == 0 {
+				continue
+			}
+			var buckets []string
+			for i, n := range h.hist.Buckets {
+				if n == 0 {
+					continue
+				}
+				lo, hi := lib.BucketRange(i)
+				buckets = append(buckets, fmt.Sprintf("%d-%d: %d", lo, hi, n))
+			}
+			fmt.Printf("%s %s: %s\n", s.Name, h.name, strings.Join(buckets, ", "))
+		}
+	}
+}
+
+func runInfoWhatIfCmd(c *cli.Context) error {
+	if c.Args().Len() != 3 {
+		return xerrors.Errorf("wrong number of args, need state root, actor address and state field")
+	}
+	stateRootIn, err := cid.Decode(c.Args().First())
+	if err != nil {
+		return err
+	}
+	addr, err := address.NewFromString(c.Args().Get(1))
+	if err != nil {
+		return err
+	}
+	field := c.Args().Get(2)
+	chn := lib.Chain{}
+	store, err := chn.LoadCborStore(c.Context)
+	if err != nil {
+		return err
+	}
+	tree, err := lib.LoadStateTree(c.Context, store, stateRootIn)
+	if err != nil {
+		return err
+	}
+	idAddr, err := tree.ResolveAddress(c.Context, store, addr)
+	if err != nil {
+		return err
+	}
+	layouts, err := lib.CollectionWhatIf(c.Context, store, tree, idAddr, field, c.IntSlice("bitwidths"))
+	if err != nil {
+		return err
+	}
+	if c.Bool("json") {
+		j, err := json.MarshalIndent(layouts, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", j)
+		return nil
+	}
+	fmt.Printf("%s of %s, %d entries\n", field, idAddr, layouts[0].Stats.Entries)
+	fmt.Printf("bitwidth,layout,nodes,bytes,max depth,fanout use,mean blocks per read or write,mean bytes per read or write\n")
+	for _, l := range layouts {
+		layout := "rebuilt"
+		if l.Current {
+			layout = "current"
+		}
+		s := l.Stats
+		fmt.Printf("%d,%s,%d,%d,%d,%.3f,%.2f,%.0f\n", l.Bitwidth, layout, s.Nodes, s.Bytes, s.Depth, s.FanoutUse(), s.MeanPathBlocks(), s.MeanPathBytes())
+	}
+	return nil
+}
+
+func runInfoSizeCmd(c *cli.Context) error {
+	if !c.Args().Present() {
+		return xerrors.Errorf("not enough args, need state root")
+	}
+	stateRootIn, err := cid.Decode(c.Args().First())
+	if err != nil {
+		return err
+	}
+	chn := lib.Chain{}
+	store, err := chn.LoadCborStore(c.Context)
+	if err != nil {
+		return err
+	}
+	tree, err := lib.LoadStateTree(c.Context, store, stateRootIn)
+	if err != nil {
+		return err
+	}
+	report, err := lib.StateSize(c.Context, store, tree, c.Int("top"))
+	if err != nil {
+		return err
+	}
+	if c.Bool("json") {
+		j, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", j)
+		return nil
+	}
+	printStateSize(report)
+	return nil
+}
+
+// printStateSize prints CSV tables of the storage of actor types, the
+// largest actors and the state fields of each type.
+func printStateSize(r *lib.StateSizeReport) {
+	share := func(bytes uint64) float64 {
+		if r.Total.Bytes == 0 {
+			return 0
+		}
+		return float64(bytes) / float64(r.Total.Bytes)
+	}
+	fmt.Printf("state %s: %d blocks, %d bytes, %d links to blocks already counted\n", r.Root, r.Total.Blocks, r.Total.Bytes, r.SharedRefs)
+	fmt.Printf("\nactor type,actors,blocks,bytes,share of bytes\n")
+	fmt.Printf("state tree,,%d,%d,%.4f\n", r.Tree.Blocks, r.Tree.Bytes, share(r.Tree.Bytes))
+	for _, t := range r.Types {
+		fmt.Printf("%s,%d,%d,%d,%.4f\n", t.Type, t.Actors, t.Blocks, t.Bytes, share(t.Bytes))
+	}
+	fmt.Printf("\naddress,actor type,blocks,bytes,share of bytes\n")
+	for _, a := range r.Actors {
+		fmt.Printf("%s,%s,%d,%d,%.4f\n", a.Address, a.Type, a.Blocks, a.Bytes, share(a.Bytes))
+	}
+	fmt.Printf("\nactor type,field,blocks,bytes,share of bytes\n")
+	for _, f := range r.Fields {
+		fmt.Printf("%s,%s,%d,%d,%.4f\n", f.Type, f.Field, f.Blocks, f.Bytes, share(f.Bytes))
+	}
+}
+
+func runInfoChurnCmd(c *cli.Context) error {
+	if !c.Args().Present() {
+		return xerrors.Errorf("not enough args, need chain head to walk from")
+	}
+	from, to := c.Int64("from"), c.Int64("to")
+	if from > to {
+		return xerrors.Errorf("empty range, --from %d is above --to %d", from, to)
+	}
+	bcid, err := cid.Decode(c.Args().First())
+	if err != nil {
+		return err
+	}
+	chn := lib.Chain{}
+	preloadStr := c.String("preload")
+	maybePreload(c.Context, &chn, preloadStr)
+	store, err := chn.LoadCborStore(c.Context)
+	if err != nil {
+		return err
+	}
+
+	// Collect the states in range and the one before it, the iterator walks
+	// from head to genesis
+	iter, err := chn.NewChainStateIterator(c.Context, bcid)
+	if err != nil {
+		return err
+	}
+	var states []lib.IterVal
+	for !iter.Done() {
+		val := iter.Val()
+		if val.Height <= to {
+			states = append(states, val)
+		}
+		if val.Height < from {
+			break
+		}
+		if err := iter.Step(c.Context); err != nil {
+			return err
+		}
+	}
+	if len(states) < 2 {
+		return xerrors.Errorf("need at least two states between epochs %d and %d", from, to)
+	}
+	for i, j := 0, len(states)-1; i < j; i, j = i+1, j-1 {
+		states[i], states[j] = states[j], states[i]
+	}
+
+	loadBlocks := func(val lib.IterVal) (lib.StateBlocks, error) {
+		tree, err := lib.LoadStateTree(c.Context, store, val.State)
+		if err != nil {
+			return nil, err
+		}
+		return lib.LoadStateBlocks(c.Context, store, tree)
+	}
+	prev, err := loadBlocks(states[0])
+	if err != nil {
+		return err
+	}
+	if !c.Bool("json") {
+		fmt.Printf("epoch,state,new blocks,new bytes,shared blocks,shared bytes,dropped blocks,dropped bytes,share of bytes preloaded\n")
+	}
+	var churns []lib.StateChurn
+	for _, val := range states[1:] {
+		blocks, err := loadBlocks(val)
+		if err != nil {
+			return xerrors.Errorf("failed to walk state at epoch %d: %w", val.Height, err)
+		}
+		churn := lib.StateChurn{Height: val.Height, State: val.State}
+		churn.BlockChurn, churn.Owners = lib.DiffStateBlocks(prev, blocks)
+		churns = append(churns, churn)
+		if !c.Bool("json") {
+			fmt.Printf("%d,%s,%d,%d,%d,%d,%d,%d,%.4f\n", val.Height, val.State, churn.New.Blocks, churn.New.Bytes,
+				churn.Shared.Blocks, churn.Shared.Bytes, churn.Dropped.Blocks, churn.Dropped.Bytes, sharedShare(churn.BlockChurn))
+		}
+		prev = blocks
+	}
+	summary := lib.SummarizeChurn(churns)
+	if c.Bool("json") {
+		j, err := json.MarshalIndent(struct {
+			States  []lib.StateChurn
+			Summary lib.ChurnSummary
+		}{churns, summary}, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", j)
+		return nil
+	}
+	fmt.Printf("\n%d states between epochs %d and %d, mean per state: %d new bytes, %d dropped bytes\n", summary.States, summary.From, summary.To,
+		summary.New.Bytes/uint64(summary.States), summary.Dropped.Bytes/uint64(summary.States))
+	fmt.Printf("actor type,new blocks,new bytes,shared blocks,shared bytes,dropped blocks,dropped bytes,share of bytes preloaded\n")
+	for _, o := range summary.Owners {
+		fmt.Printf("%s,%d,%d,%d,%d,%d,%d,%.4f\n", o.Owner, o.New.Blocks, o.New.Bytes, o.Shared.Blocks, o.Shared.Bytes,
+			o.Dropped.Blocks, o.Dropped.Bytes, sharedShare(o.BlockChurn))
+	}
+	return nil
+}
+
+// sharedShare is the share of the bytes of a state already in the previous
+// state, which preloading the previous state saves loading.
+func sharedShare(churn lib.BlockChurn) float64 {
+	total := churn.New.Bytes + churn.Shared.Bytes
+	if total == 0 {
+		return 0
+	}
+	return float64(churn.Shared.Bytes) / float64(total)
+}
+
+func runInfoActorCmd(c *cli.Context) error {
+	if c.Args().Len() != 2 {
+		return xerrors.Errorf("wrong number of args, need state root and actor address")
+	}
+	stateRootIn, err := cid.Decode(c.Args().First())
+	if err != nil {
+		return err
+	}
+	addr, err := address.NewFromString(c.Args().Get(1))
+	if err != nil {
+		return err
+	}
+	chn := lib.Chain{}
+	store, err := chn.LoadCborStore(c.Context)
+	if err != nil {
+		return err
+	}
+	tree, err := lib.LoadStateTree(c.Context, store, stateRootIn)
+	if err != nil {
+		return err
+	}
+	idAddr, err := tree.ResolveAddress(c.Context, store, addr)
+	if err != nil {
+		return err
+	}
+	a, found, err := tree.GetActor(idAddr)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return xerrors.Errorf("actor %s not found in %s", addr, stateRootIn)
+	}
+	return printActorJSON(c.Context, store, idAddr, a, c.Int("depth"))
+}
+
+func runInfoMinerCmd(c *cli.Context) error {
+	if c.Args().Len() != 2 {
+		return xerrors.Errorf("wrong number of args, need state root and miner address")
+	}
+	stateRootIn, err := cid.Decode(c.Args().First())
+	if err != nil {
+		return err
+	}
+	addr, err := address.NewFromString(c.Args().Get(1))
+	if err != nil {
+		return err
+	}
+	chn := lib.Chain{}
+	store, err := chn.LoadCborStore(c.Context)
+	if err != nil {
+		return err
+	}
+	tree, err := lib.LoadStateTree(c.Context, store, stateRootIn)
+	if err != nil {
+		return err
+	}
+	idAddr, err := tree.ResolveAddress(c.Context, store, addr)
+	if err != nil {
+		return err
+	}
+	report, err := lib.NewMinerReport(c.Context, store, tree, idAddr)
+	if err != nil {
+		return err
+	}
+	if c.Bool("json") {
+		j, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", j)
+		return nil
+	}
+	printMinerReport(report)
+	return nil
+}
+
+func printMinerReport(r *lib.MinerReport) {
+	fmt.Printf("Miner %s (actors %s)\n", r.Address, r.Version)
+	fmt.Printf("owner: %s, worker: %s, control: %v\n", r.Info.Owner, r.Info.Worker, r.Info.ControlAddresses)
+	fmt.Printf("peer ID: %s, sector size: %s, seal proof: %d\n", r.Info.PeerID, types.SizeStr(types.NewInt(uint64(r.Info.SectorSize))), r.Info.SealProofType)
+	fmt.Printf("balance: %s, available: %s\n", types.FIL(r.Balance), types.FIL(r.Available))
+	fmt.Printf("locked funds: %s, initial pledge: %s, precommit deposits: %s, fee debt: %s\n",
+		types.FIL(r.LockedFunds), types.FIL(r.InitialPledge), types.FIL(r.PreCommitDeposits), types.FIL(r.FeeDebt))
+	fmt.Printf("precommits: %d\n", r.PreCommits)
+	if r.VestingEntries > 0 {
+		fmt.Printf("vesting: %s in %d entries, epochs %d to %d\n", types.FIL(r.VestingTotal), r.VestingEntries, r.VestingFirst, r.VestingLast)
+	} else {
+		fmt.Printf("vesting: none\n")
+	}
+	dl := r.NextDeadline
+	fmt.Printf("next deadline: %d, open %d to %d, challenge %d, proving period start %d\n", dl.Index, dl.Open, dl.Close, dl.Challenge, dl.PeriodStart)
+	total := r.Totals()
+	fmt.Printf("sectors: %d, live: %d, faulty: %d, recovering: %d, terminated: %d, unproven: %d\n",
+		total.Sectors, total.Live, total.Faulty, total.Recovering, total.Terminated, total.Unproven)
+	fmt.Printf("deadline,partition,sectors,live,faulty,recovering,terminated,unproven\n")
+	for _, d := range r.Deadlines {
+		for _, p := range d.Partitions {
+			fmt.Printf("%d,%d,%d,%d,%d,%d,%d,%d\n", d.Index, p.Index, p.Sectors, p.Live, p.Faulty, p.Recovering, p.Terminated, p.Unproven)
+		}
+	}
+}
+
+func runExportSectorsCmd(c *cli.Context) error {
 	if !c.Args().Present() {
 		return xerrors.Errorf("not enough args, need state root")
 	}
@@ -416,12 +1757,12 @@ func runExportSectorsCmd(c *cli.Context) error {
 		return err
 	}
 
-	tree, err := loadStateTree(c.Context, store, stateRootIn)
+	tree, err := lib.LoadStateTree(c.Context, store, stateRootIn)
 	if err != nil {
 		return err
 	}
 
-	sectors, err := lib.ExportSectors(c.Context, adt0.WrapStore(c.Context, store), tree)
+	sectors, err := lib.ExportSectors(c.Context, adt0.WrapStore(c.Context, store), tree.Tree)
 	if err != nil {
 		return err
 	}
@@ -447,29 +1788,249 @@ func runExportSectorsCmd(c *cli.Context) error {
 
 /* Helpers */
 
-func cpuProfile(c *cli.Context) (func(), error) {
-	val := c.String("cpuprofile")
-	if val == "" { // flag not set do nothing and defer nothing
-		return func() {}, nil
+// startProfiles starts the profiles requested by the global profiling flags.
+// The returned function stops them and writes the results to disk.
+func startProfiles(c *cli.Context) (func(), error) {
+	var stops []func()
+	cleanUp := func() {
+		for i := len(stops) - 1; i >= 0; i-- {
+			stops[i]()
+		}
+	}
+
+	if val := c.String("cpuprofile"); val != "" {
+		f, err := os.Create(val)
+		if err != nil {
+			cleanUp()
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			_ = f.Close()
+			cleanUp()
+			return nil, err
+		}
+		stops = append(stops, func() {
+			pprof.StopCPUProfile()
+			closeProfileFile(f, "cpuprofile", val)
+		})
+	}
+	if val := c.String("trace"); val != "" {
+		f, err := os.Create(val)
+		if err != nil {
+			cleanUp()
+			return nil, err
+		}
+		if err := trace.Start(f); err != nil {
+			_ = f.Close()
+			cleanUp()
+			return nil, err
+		}
+		stops = append(stops, func() {
+			trace.Stop()
+			closeProfileFile(f, "trace", val)
+		})
+	}
+	if val := c.String("blockprofile"); val != "" {
+		runtime.SetBlockProfileRate(1)
+		stops = append(stops, func() {
+			writeProfile("block", val)
+			runtime.SetBlockProfileRate(0)
+		})
+	}
+	if val := c.String("memprofile"); val != "" {
+		stops = append(stops, func() {
+			runtime.GC() // report live heap as of the end of the command
+			writeProfile("heap", val)
+		})
+	}
+	if val := c.String("allocs"); val != "" {
+		stops = append(stops, func() {
+			writeProfile("allocs", val)
+		})
+	}
+
+	return cleanUp, nil
+}
+
+func writeProfile(name, path string) {
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Printf("failed to create %s profile file %s: %s\n", name, path, err)
+		return
+	}
+	if err := pprof.Lookup(name).WriteTo(f, 0); err != nil {
+		fmt.Printf("failed to write %s profile file %s: %s\n", name, path, err)
+	}
+	closeProfileFile(f, name, path)
+}
+
+func closeProfileFile(f *os.File, name, path string) {
+	if err := f.Close(); err != nil {
+		fmt.Printf("failed to close %s file %s: %s\n", name, path, err)
+	}
+}
+
+// formatMemStats renders migration memory statistics for a result line.
+func formatMemStats(ms lib.MemStats) string {
+	return fmt.Sprintf("peak rss: %s, peak heap: %s, allocated: %s in %d objects, gc: %d (%v paused)",
+		formatBytes(ms.PeakRSS), formatBytes(ms.PeakHeapAlloc), formatBytes(ms.TotalAlloc), ms.Mallocs, ms.NumGC, ms.PauseTotal)
+}
+
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+// migrationReporter runs migrations with the optional cache and
+// measurements requested by the --cache, --cache-file, --cache-check and
+// --actor-profile flags.
+type migrationReporter struct {
+	cache       *lib.MigrationCache
+	cachePath   string
+	cacheCheck  bool
+	cacheReport *lib.CacheReport
+	profilePath string
+	profiles    []*lib.MigrationProfile
+	profiler    *lib.ProfilingStore
+}
+
+func newMigrationReporter(c *cli.Context, mig lib.Migration) (*migrationReporter, error) {
+	r := &migrationReporter{
+		cachePath:   c.String("cache-file"),
+		cacheCheck:  c.Bool("cache-check"),
+		profilePath: c.String("actor-profile"),
+	}
+	if (r.cachePath != "" || c.Bool("cache")) && mig.Name != lib.DefaultMigration {
+		return nil, xerrors.Errorf("the migration cache only supports migration %s", lib.DefaultMigration)
+	}
+	if r.profilePath != "" {
+		if mig.MigrateSerially == nil {
+			return nil, xerrors.Errorf("migration %s cannot be run serially to profile actors", mig.Name)
+		}
+		if r.cachePath != "" || c.Bool("cache") {
+			return nil, xerrors.Errorf("--actor-profile cannot be used with the migration cache")
+		}
+	}
+	if r.cachePath != "" {
+		cache, err := lib.LoadMigrationCache(r.cachePath)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("loaded migration cache of %d actor heads from %s\n", cache.Len(), r.cachePath)
+		r.cache = cache
+	} else if c.Bool("cache") {
+		r.cache = lib.NewMigrationCache()
 	}
+	return r, nil
+}
 
-	// val is output path of cpuprofile file
-	f, err := os.Create(val)
+// wrapStore returns the store to run the migration of stateRootIn with.
+func (r *migrationReporter) wrapStore(ctx context.Context, store cbornode.IpldStore, stateRootIn cid.Cid) (cbornode.IpldStore, error) {
+	r.profiler = nil
+	if r.profilePath == "" {
+		return store, nil
+	}
+	profiler, err := lib.NewProfilingStore(ctx, store, stateRootIn)
 	if err != nil {
-		return nil, err
+		return nil, xerrors.Errorf("failed to set up actor profiling: %w", err)
+	}
+	r.profiler = profiler
+	return profiler, nil
+}
+
+// migrate runs mig on stateRootIn, serially when profiling actors and
+// reusing cached actor results if the cache is enabled.
+func (r *migrationReporter) migrate(ctx context.Context, store cbornode.IpldStore, mig lib.Migration, stateRootIn cid.Cid, height abi.ChainEpoch) (cid.Cid, error) {
+	r.cacheReport = nil
+	if r.profiler != nil {
+		defer r.profiler.Stop()
+		return mig.MigrateSerially(ctx, store, stateRootIn, height)
+	}
+	if r.cache == nil {
+		return mig.Migrate(ctx, store, stateRootIn, height)
 	}
-	err = pprof.StartCPUProfile(f)
+	stateRootOut, report, err := r.cache.Migrate(ctx, store, stateRootIn, height)
 	if err != nil {
-		return nil, err
+		return cid.Undef, err
+	}
+	r.cacheReport = report
+	return stateRootOut, nil
+}
+
+// report prints the measurements of a successful migration.  With
+// --cache-check the migration is run again without the cache to measure the
+// time the cache saved.
+func (r *migrationReporter) report(ctx context.Context, store cbornode.IpldStore, mig lib.Migration, stateRootIn, stateRootOut cid.Cid, height abi.ChainEpoch, duration time.Duration) error {
+	if r.profiler != nil {
+		profile := r.profiler.Profile(stateRootIn, height, duration)
+		printMigrationProfile(profile)
+		r.profiles = append(r.profiles, profile)
+	}
+	report := r.cacheReport
+	if report == nil {
+		return nil
+	}
+	saved := "run with --cache-check to measure the time saved"
+	if r.cacheCheck {
+		start := time.Now()
+		fullRootOut, err := mig.Migrate(ctx, store, stateRootIn, height)
+		fullDuration := time.Since(start)
+		if err != nil {
+			return xerrors.Errorf("failed to run full migration to check the cache: %w", err)
+		}
+		if !fullRootOut.Equals(stateRootOut) {
+			return xerrors.Errorf("cached migration of %s gave %s, full migration gave %s", stateRootIn, stateRootOut, fullRootOut)
+		}
+		saved = fmt.Sprintf("full migration: %v -- time saved: %v", fullDuration, fullDuration-duration)
+	}
+	fmt.Printf("%s cache hits: %d/%d (%.1f%%) -- %s\n", stateRootIn, report.Hits, report.Actors, 100*report.HitRate(), saved)
+	actorTypes := make([]string, 0, len(report.ActorsByType))
+	for actorType := range report.ActorsByType {
+		actorTypes = append(actorTypes, actorType)
 	}
+	sort.Strings(actorTypes)
+	for _, actorType := range actorTypes {
+		fmt.Printf("    %s: %d/%d\n", actorType, report.HitsByType[actorType], report.ActorsByType[actorType])
+	}
+	return nil
+}
 
-	return func() {
-		pprof.StopCPUProfile()
-		err := f.Close()
+// save writes the cache and actor profiles to the paths given on the command line.
+func (r *migrationReporter) save() error {
+	if r.cache != nil && r.cachePath != "" {
+		if err := r.cache.Save(r.cachePath); err != nil {
+			return xerrors.Errorf("failed to save migration cache: %w", err)
+		}
+	}
+	if r.profilePath != "" {
+		raw, err := json.MarshalIndent(r.profiles, "", "  ")
 		if err != nil {
-			fmt.Printf("failed to close cpuprofile file %s: %s\n", val, err)
+			return err
+		}
+		if err := ioutil.WriteFile(r.profilePath, raw, 0644); err != nil {
+			return xerrors.Errorf("failed to write actor profile: %w", err)
+		}
+	}
+	return nil
+}
+
+func printMigrationProfile(profile *lib.MigrationProfile) {
+	fmt.Printf("%s actor migration times:\n", profile.StateRoot)
+	fmt.Printf("    %-24s %8s %14s %12s %12s %12s\n", "type", "count", "total", "p50", "p99", "max")
+	for _, tp := range profile.Types {
+		fmt.Printf("    %-24s %8d %14v %12v %12v %12v\n", tp.Type, tp.Count, tp.Total, tp.P50, tp.P99, tp.Max)
+		for _, slow := range tp.Slowest {
+			fmt.Printf("        %s %v\n", slow.Address, slow.Duration)
 		}
-	}, nil
+	}
 }
 
 func maybePreload(ctx context.Context, chn *lib.Chain, preloadStr string) error {
@@ -489,34 +2050,403 @@ func maybePreload(ctx context.Context, chn *lib.Chain, preloadStr string) error
 	return err
 }
 
-func validate(ctx context.Context, store cbornode.IpldStore, priorEpoch abi.ChainEpoch, stateRoot cid.Cid) error {
-	tree, err := loadStateTree(ctx, store, stateRoot)
+// validationReporter runs invariant checks, prints their results grouped by
+// actor and category and counts the states with violations not accepted by
+// the baseline given on the command line.
+type validationReporter struct {
+	json        bool
+	savePath    string
+	networkName string
+	totalSupply string
+	baseline    []lib.Violation
+	// network is set by useNetwork, nil until then or if the network is
+	// unknown.
+	network  *lib.Network
+	resolved bool
+	results  []*lib.ValidationResult
+	failed   int
+}
+
+func newValidationReporter(c *cli.Context) (*validationReporter, error) {
+	vr := &validationReporter{
+		json:        c.Bool("json"),
+		savePath:    c.String("save"),
+		networkName: c.String("network"),
+		totalSupply: c.String("total-supply"),
+	}
+	if path := c.String("baseline"); path != "" {
+		baseline, err := lib.LoadValidationBaseline(path)
+		if err != nil {
+			return nil, err
+		}
+		vr.baseline = baseline
+	}
+	return vr, nil
+}
+
+// useNetwork resolves the network of the migration input at actorsRoot and
+// applies its policy, so the migration and the checks of its input and
+// output run under the same policy.  Only the first call resolves the
+// network.  An unknown network is only an error if required, as when states
+// are to be validated, otherwise migrations run under the default policy.
+func (vr *validationReporter) useNetwork(ctx context.Context, store cbornode.IpldStore, actorsRoot cid.Cid, required bool) error {
+	if vr.resolved {
+		return nil
+	}
+	vr.resolved = true
+	network, err := resolveNetwork(ctx, store, actorsRoot, vr.networkName, vr.totalSupply)
+	if err != nil {
+		if required {
+			return err
+		}
+		fmt.Printf("migrating with the default policy: %v\n", err)
+		return nil
+	}
+	network.ApplyPolicy()
+	vr.network = &network
+	return nil
+}
+
+// validateMigration checks the output of a migration if the migration has a
+// validator for its output version.
+func (vr *validationReporter) validateMigration(ctx context.Context, store cbornode.IpldStore, mig lib.Migration, priorEpoch abi.ChainEpoch, stateRootOut cid.Cid) error {
+	if mig.Validate == nil {
+		fmt.Printf("Validation: %s -- skipped, migration %s has no validator\n", stateRootOut, mig.Name)
+		return nil
+	}
+	return vr.validate(ctx, store, "Validation", priorEpoch, stateRootOut, mig.Validate)
+}
+
+// preValidateMigration checks the input of a migration if the migration has
+// a validator for its input version.
+func (vr *validationReporter) preValidateMigration(ctx context.Context, store cbornode.IpldStore, mig lib.Migration, priorEpoch abi.ChainEpoch, stateRootIn cid.Cid) error {
+	if mig.ValidateInput == nil {
+		fmt.Printf("Pre-validation: %s -- skipped, migration %s has no input validator\n", stateRootIn, mig.Name)
+		return nil
+	}
+	return vr.validate(ctx, store, "Pre-validation", priorEpoch, stateRootIn, mig.ValidateInput)
+}
+
+// validate checks stateRoot and prints the result.  Violations do not make
+// it return an error, call err once all states are checked.
+func (vr *validationReporter) validate(ctx context.Context, store cbornode.IpldStore, label string, priorEpoch abi.ChainEpoch, stateRoot cid.Cid, check lib.ValidateFunc) error {
+	if vr.network == nil {
+		return xerrors.Errorf("cannot validate %s, no network resolved", stateRoot)
+	}
+	network := *vr.network
+	tree, err := lib.LoadStateTree(ctx, store, stateRoot)
 	if err != nil {
-		return xerrors.Errorf("failed to load tree: %w", err)
+		return err
 	}
-	expectedBalance := builtin2.TotalFilecoin
 	start := time.Now()
-	acc, err := states2.CheckStateInvariants(tree, expectedBalance, priorEpoch)
+	acc, err := check(ctx, store, tree.Actors, priorEpoch, network)
 	duration := time.Since(start)
 	if err != nil {
-		return xerrors.Errorf("failed to check state invariants", err)
+		return xerrors.Errorf("failed to check state invariants: %w", err)
 	}
-	if acc.IsEmpty() {
-		fmt.Printf("Validation: %s -- no errors -- %v\n", stateRoot, duration)
+	result := lib.NewValidationResult(stateRoot, priorEpoch, duration, acc)
+	result.Network = network.Name
+	result.Version = tree.Version
+	_, err = vr.report(label, result)
+	return err
+}
+
+// report records and prints a validation result and returns its violations
+// not accepted by the baseline.
+func (vr *validationReporter) report(label string, result *lib.ValidationResult) ([]lib.Violation, error) {
+	vr.results = append(vr.results, result)
+	violations := result.AcceptBaseline(vr.baseline)
+	if len(violations) > 0 {
+		vr.failed++
+	}
+
+	if vr.json {
+		raw, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		fmt.Println(string(raw))
+		return violations, nil
+	}
+	accepted := ""
+	if vr.baseline != nil {
+		accepted = fmt.Sprintf(" -- %d accepted by baseline", len(result.Violations)-len(violations))
+	}
+	if len(violations) == 0 {
+		fmt.Printf("%s: %d -- %s -- no errors%s -- %v\n", label, result.Epoch, result.StateRoot, accepted, result.Duration)
+		return violations, nil
+	}
+	fmt.Printf("%s: %d -- %s -- %d errors%s -- %v\n", label, result.Epoch, result.StateRoot, len(violations), accepted, result.Duration)
+	for _, group := range lib.GroupViolations(violations) {
+		name := group.Address
+		if name == "" {
+			name = "state tree"
+		}
+		fmt.Printf("    %s %s:\n", name, group.Category)
+		for _, msg := range group.Messages {
+			fmt.Printf("        %s\n", msg)
+		}
+	}
+	return violations, nil
+}
+
+// checkStateVersion returns an error naming the validate command to use if
+// the actors tree at actorsRoot is not of the expected version.
+func checkStateVersion(ctx context.Context, store cbornode.IpldStore, actorsRoot cid.Cid, version lib.ActorsVersion) error {
+	actual, err := lib.DetectActorsVersion(ctx, store, actorsRoot)
+	if err != nil {
+		return err
+	}
+	if actual != version {
+		return xerrors.Errorf("state %s has actors version %s, use validate %s", actorsRoot, actual, actual)
+	}
+	return nil
+}
+
+// resolveNetwork returns the network called networkName, or the network
+// detected from the state at stateRoot if networkName is empty.  A non-empty
+// totalSupply overrides the network's expected supply and allows validating
+// states of unknown networks.  Callers apply the network's policy.
+func resolveNetwork(ctx context.Context, store cbornode.IpldStore, stateRoot cid.Cid, networkName, totalSupply string) (lib.Network, error) {
+	var network lib.Network
+	var err error
+	if networkName != "" {
+		network, err = lib.GetNetwork(networkName)
 	} else {
-		fmt.Printf("Validation: %s -- with errors -- %v\n%s\n", stateRoot, duration, strings.Join(acc.Messages(), "\n"))
+		network, err = lib.DetectNetwork(ctx, store, stateRoot)
+		if err != nil && totalSupply != "" {
+			network, err = lib.Network{Name: "custom"}, nil
+		}
+	}
+	if err != nil {
+		return lib.Network{}, err
+	}
+	if totalSupply != "" {
+		supply, err := types.ParseFIL(totalSupply)
+		if err != nil {
+			return lib.Network{}, xerrors.Errorf("failed to parse total supply: %w", err)
+		}
+		network.TotalFilecoin = abi.TokenAmount(supply)
+	}
+	return network, nil
+}
+
+// err saves all results to the path given on the command line and returns
+// an error if any state had violations not accepted by the baseline.
+func (vr *validationReporter) err() error {
+	if vr.savePath != "" {
+		if err := lib.WriteValidationResults(vr.savePath, vr.results); err != nil {
+			return err
+		}
+	}
+	if vr.failed > 0 {
+		return xerrors.Errorf("%d of %d validated states have invariant violations", vr.failed, len(vr.results))
 	}
 	return nil
 }
 
-func loadStateTree(ctx context.Context, store cbornode.IpldStore, stateRoot cid.Cid) (*states2.Tree, error) {
+// audit prints a conservation audit of a v0 to v2 migration.
+func audit(ctx context.Context, store cbornode.IpldStore, stateRootIn, stateRootOut cid.Cid) error {
+	start := time.Now()
+	actorsRootIn, err := lib.LoadActorsRoot(ctx, store, stateRootIn)
+	if err != nil {
+		return err
+	}
+	actorsRootOut, err := lib.LoadActorsRoot(ctx, store, stateRootOut)
+	if err != nil {
+		return err
+	}
+	report, err := lib.AuditMigration(ctx, store, actorsRootIn, actorsRootOut)
+	if err != nil {
+		return xerrors.Errorf("failed to audit migration: %w", err)
+	}
+	duration := time.Since(start)
+	result := "no discrepancies"
+	if len(report.Discrepancies) > 0 {
+		result = fmt.Sprintf("%d discrepancies", len(report.Discrepancies))
+	}
+	fmt.Printf("Audit: %s => %s -- %s -- %v\n", stateRootIn, stateRootOut, result, duration)
+	fmt.Printf("actors: %d => %d, total balance: %v => %v\n", report.ActorsIn, report.ActorsOut, report.BalanceIn, report.BalanceOut)
+	fmt.Printf("burnt funds: %v, miner debt repaid: %v\n", report.BurntFundsIn, report.MinerDebt)
+	for _, d := range report.Discrepancies {
+		if d.Address == address.Undef {
+			fmt.Printf("total: %s\n", d.Message)
+		} else {
+			fmt.Printf("%s: %s\n", d.Address, d.Message)
+		}
+	}
+	return nil
+}
+
+// actorJSON is the JSON representation of an actor and its decoded state.
+type actorJSON struct {
+	Address address.Address
+	Code    string
+	Head    cid.Cid
+	Nonce   uint64
+	Balance abi.TokenAmount
+	State   interface{}
+}
+
+// printActorJSON prints an actor with its state expanded to depth.
+func printActorJSON(ctx context.Context, store cbornode.IpldStore, addr address.Address, a *states2.Actor, depth int) error {
+	st, err := lib.ExpandActorState(ctx, store, a, depth)
+	if err != nil {
+		return err
+	}
+	j, err := json.MarshalIndent(actorJSON{
+		Address: addr,
+		Code:    lbuiltin.ActorNameByCode(a.Code),
+		Head:    a.Head,
+		Nonce:   a.CallSeqNum,
+		Balance: a.Balance,
+		State:   st,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", j)
+	return nil
+}
+
+// printActorDiffs prints every actor differing between the expected and the
+// actual migration output.  Both roots must be v2 actors HAMT roots.
+func printActorDiffs(ctx context.Context, store cbornode.IpldStore, expected, actual cid.Cid) error {
 	adtStore := adt0.WrapStore(ctx, store)
-	var treeTop types.StateRoot
-	err := store.Get(ctx, stateRoot, &treeTop)
+	expectedTree, err := states2.LoadTree(adtStore, expected)
+	if err != nil {
+		return err
+	}
+	actualTree, err := states2.LoadTree(adtStore, actual)
+	if err != nil {
+		return err
+	}
+	diffs, err := lib.DiffStateTrees(expectedTree, actualTree)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d actors differ between expected %s and actual %s\n", len(diffs), expected, actual)
+	for _, d := range diffs {
+		printActorDiff(d)
+	}
+	return nil
+}
+
+// printActorDiff prints an added, removed or changed actor and which of its
+// code, head, nonce and balance changed.
+func printActorDiff(d lib.ActorDiff) {
+	switch {
+	case d.Before == nil:
+		fmt.Printf("+ %s %s head=%s nonce=%d balance=%v\n", d.Address, lbuiltin.ActorNameByCode(d.After.Code), d.After.Head, d.After.CallSeqNum, d.After.Balance)
+	case d.After == nil:
+		fmt.Printf("- %s %s head=%s nonce=%d balance=%v\n", d.Address, lbuiltin.ActorNameByCode(d.Before.Code), d.Before.Head, d.Before.CallSeqNum, d.Before.Balance)
+	default:
+		fmt.Printf("~ %s %s\n", d.Address, lbuiltin.ActorNameByCode(d.After.Code))
+		if !d.Before.Code.Equals(d.After.Code) {
+			fmt.Printf("    code:    %s => %s\n", d.Before.Code, d.After.Code)
+		}
+		if !d.Before.Head.Equals(d.After.Head) {
+			fmt.Printf("    head:    %s => %s\n", d.Before.Head, d.After.Head)
+		}
+		if d.Before.CallSeqNum != d.After.CallSeqNum {
+			fmt.Printf("    nonce:   %d => %d\n", d.Before.CallSeqNum, d.After.CallSeqNum)
+		}
+		if !d.Before.Balance.Equals(d.After.Balance) {
+			fmt.Printf("    balance: %v => %v\n", d.Before.Balance, d.After.Balance)
+		}
+	}
+}
+
+func runDiffCmd(c *cli.Context) error {
+	if c.Args().Len() != 2 {
+		return xerrors.Errorf("wrong number of args, need two state roots")
+	}
+	rootA, err := cid.Decode(c.Args().First())
+	if err != nil {
+		return err
+	}
+	rootB, err := cid.Decode(c.Args().Get(1))
+	if err != nil {
+		return err
+	}
+	chn := lib.Chain{}
+	store, err := chn.LoadCborStore(c.Context)
+	if err != nil {
+		return err
+	}
+	treeA, err := lib.LoadStateTree(c.Context, store, rootA)
+	if err != nil {
+		return err
+	}
+	treeB, err := lib.LoadStateTree(c.Context, store, rootB)
+	if err != nil {
+		return err
+	}
+	if c.Bool("migration") {
+		return printMigrationDiff(c.Context, store, treeA, treeB)
+	}
+	diffs, err := lib.DiffStateTrees(treeA.Tree, treeB.Tree)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d actors differ between %s and %s\n", len(diffs), rootA, rootB)
+	for _, d := range diffs {
+		printActorDiff(d)
+		if d.Before == nil || d.After == nil || c.Bool("actors-only") {
+			continue
+		}
+		fields, err := lib.DiffActorStates(c.Context, store, d.Before, d.After)
+		if err != nil {
+			return xerrors.Errorf("failed to diff state of %s: %w", d.Address, err)
+		}
+		if err := printFieldDiffs(fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printMigrationDiff prints the actors of the v0 state treeIn whose v2
+// migration in treeOut differs beyond the expected changes.
+func printMigrationDiff(ctx context.Context, store cbornode.IpldStore, treeIn, treeOut *lib.StateTree) error {
+	diffs, err := lib.DiffMigration(ctx, store, treeIn.Actors, treeOut.Actors)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	_, _ = fmt.Fprintf(os.Stderr, "State root version: %v\n", treeTop.Version)
-	tree, err := states2.LoadTree(adtStore, treeTop.Actors)
-	return tree, nil
+	fmt.Printf("%d actors differ beyond the expected migration changes between %s and %s\n", len(diffs), treeIn.Root, treeOut.Root)
+	for _, d := range diffs {
+		if d.Before == nil || d.After == nil {
+			printActorDiff(d.ActorDiff)
+			continue
+		}
+		fmt.Printf("~ %s %s\n", d.Address, lbuiltin.ActorNameByCode(d.After.Code))
+		if err := printFieldDiffs(d.Fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printFieldDiffs prints state field differences below their actor, with
+// values as compact JSON.
+func printFieldDiffs(fields []lib.FieldDiff) error {
+	for _, f := range fields {
+		before, err := json.Marshal(f.Before)
+		if err != nil {
+			return err
+		}
+		after, err := json.Marshal(f.After)
+		if err != nil {
+			return err
+		}
+		switch {
+		case f.Before == nil:
+			fmt.Printf("    + %s: %s\n", f.Path, after)
+		case f.After == nil:
+			fmt.Printf("    - %s: %s\n", f.Path, before)
+		default:
+			fmt.Printf("    %s: %s => %s\n", f.Path, before, after)
+		}
+	}
+	return nil
 }
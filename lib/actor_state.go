@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"context"
+
+	builtin0 "github.com/filecoin-project/specs-actors/actors/builtin"
+	account0 "github.com/filecoin-project/specs-actors/actors/builtin/account"
+	cron0 "github.com/filecoin-project/specs-actors/actors/builtin/cron"
+	init0 "github.com/filecoin-project/specs-actors/actors/builtin/init"
+	market0 "github.com/filecoin-project/specs-actors/actors/builtin/market"
+	miner0 "github.com/filecoin-project/specs-actors/actors/builtin/miner"
+	multisig0 "github.com/filecoin-project/specs-actors/actors/builtin/multisig"
+	paych0 "github.com/filecoin-project/specs-actors/actors/builtin/paych"
+	power0 "github.com/filecoin-project/specs-actors/actors/builtin/power"
+	reward0 "github.com/filecoin-project/specs-actors/actors/builtin/reward"
+	system0 "github.com/filecoin-project/specs-actors/actors/builtin/system"
+	verifreg0 "github.com/filecoin-project/specs-actors/actors/builtin/verifreg"
+	builtin2 "github.com/filecoin-project/specs-actors/v2/actors/builtin"
+	account2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/account"
+	cron2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/cron"
+	init2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/init"
+	market2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/market"
+	miner2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/miner"
+	multisig2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/multisig"
+	paych2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/paych"
+	power2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/power"
+	reward2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/reward"
+	system2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/system"
+	verifreg2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/verifreg"
+	states2 "github.com/filecoin-project/specs-actors/v2/actors/states"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+)
+
+// actorStates maps every builtin actor code of actors v0 and v2 to a
+// constructor of its empty state object.
+var actorStates = map[cid.Cid]func() cbg.CBORUnmarshaler{
+	builtin0.SystemActorCodeID:           func() cbg.CBORUnmarshaler { return new(system0.State) },
+	builtin0.InitActorCodeID:             func() cbg.CBORUnmarshaler { return new(init0.State) },
+	builtin0.CronActorCodeID:             func() cbg.CBORUnmarshaler { return new(cron0.State) },
+	builtin0.AccountActorCodeID:          func() cbg.CBORUnmarshaler { return new(account0.State) },
+	builtin0.StoragePowerActorCodeID:     func() cbg.CBORUnmarshaler { return new(power0.State) },
+	builtin0.StorageMinerActorCodeID:     func() cbg.CBORUnmarshaler { return new(miner0.State) },
+	builtin0.StorageMarketActorCodeID:    func() cbg.CBORUnmarshaler { return new(market0.State) },
+	builtin0.PaymentChannelActorCodeID:   func() cbg.CBORUnmarshaler { return new(paych0.State) },
+	builtin0.MultisigActorCodeID:         func() cbg.CBORUnmarshaler { return new(multisig0.State) },
+	builtin0.RewardActorCodeID:           func() cbg.CBORUnmarshaler { return new(reward0.State) },
+	builtin0.VerifiedRegistryActorCodeID: func() cbg.CBORUnmarshaler { return new(verifreg0.State) },
+
+	builtin2.SystemActorCodeID:           func() cbg.CBORUnmarshaler { return new(system2.State) },
+	builtin2.InitActorCodeID:             func() cbg.CBORUnmarshaler { return new(init2.State) },
+	builtin2.CronActorCodeID:             func() cbg.CBORUnmarshaler { return new(cron2.State) },
+	builtin2.AccountActorCodeID:          func() cbg.CBORUnmarshaler { return new(account2.State) },
+	builtin2.StoragePowerActorCodeID:     func() cbg.CBORUnmarshaler { return new(power2.State) },
+	builtin2.StorageMinerActorCodeID:     func() cbg.CBORUnmarshaler { return new(miner2.State) },
+	builtin2.StorageMarketActorCodeID:    func() cbg.CBORUnmarshaler { return new(market2.State) },
+	builtin2.PaymentChannelActorCodeID:   func() cbg.CBORUnmarshaler { return new(paych2.State) },
+	builtin2.MultisigActorCodeID:         func() cbg.CBORUnmarshaler { return new(multisig2.State) },
+	builtin2.RewardActorCodeID:           func() cbg.CBORUnmarshaler { return new(reward2.State) },
+	builtin2.VerifiedRegistryActorCodeID: func() cbg.CBORUnmarshaler { return new(verifreg2.State) },
+}
+
+// actorTypes maps every builtin actor code of actors v0 and v2 to the name
+// of its actor type, the same in both versions.
+var actorTypes = map[cid.Cid]string{
+	builtin0.SystemActorCodeID:           "system",
+	builtin0.InitActorCodeID:             "init",
+	builtin0.CronActorCodeID:             "cron",
+	builtin0.AccountActorCodeID:          "account",
+	builtin0.StoragePowerActorCodeID:     "power",
+	builtin0.StorageMinerActorCodeID:     "miner",
+	builtin0.StorageMarketActorCodeID:    "market",
+	builtin0.PaymentChannelActorCodeID:   "paych",
+	builtin0.MultisigActorCodeID:         "multisig",
+	builtin0.RewardActorCodeID:           "reward",
+	builtin0.VerifiedRegistryActorCodeID: "verifreg",
+
+	builtin2.SystemActorCodeID:           "system",
+	builtin2.InitActorCodeID:             "init",
+	builtin2.CronActorCodeID:             "cron",
+	builtin2.AccountActorCodeID:          "account",
+	builtin2.StoragePowerActorCodeID:     "power",
+	builtin2.StorageMinerActorCodeID:     "miner",
+	builtin2.StorageMarketActorCodeID:    "market",
+	builtin2.PaymentChannelActorCodeID:   "paych",
+	builtin2.MultisigActorCodeID:         "multisig",
+	builtin2.RewardActorCodeID:           "reward",
+	builtin2.VerifiedRegistryActorCodeID: "verifreg",
+}
+
+// ActorTypeName returns the name of the actor type of code independent of
+// its actors version, e.g. miner, or the code itself if it is not a builtin
+// actor code.
+func ActorTypeName(code cid.Cid) string {
+	if name, found := actorTypes[code]; found {
+		return name
+	}
+	return code.String()
+}
+
+// LoadActorState decodes the head of an actor into the specs-actors state
+// type matching its code, e.g. a *miner0.State for a v0 miner.
+func LoadActorState(ctx context.Context, store cbornode.IpldStore, a *states2.Actor) (interface{}, error) {
+	newState, found := actorStates[a.Code]
+	if !found {
+		return nil, xerrors.Errorf("unknown actor code %s", a.Code)
+	}
+	st := newState()
+	if err := store.Get(ctx, a.Head, st); err != nil {
+		return nil, xerrors.Errorf("failed to load actor state %s: %w", a.Head, err)
+	}
+	return st, nil
+}
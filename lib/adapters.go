@@ -0,0 +1,596 @@
+package lib
+
+import (
+	"context"
+
+	address "github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/dline"
+	builtin0 "github.com/filecoin-project/specs-actors/actors/builtin"
+	market0 "github.com/filecoin-project/specs-actors/actors/builtin/market"
+	miner0 "github.com/filecoin-project/specs-actors/actors/builtin/miner"
+	power0 "github.com/filecoin-project/specs-actors/actors/builtin/power"
+	adt0 "github.com/filecoin-project/specs-actors/actors/util/adt"
+	builtin2 "github.com/filecoin-project/specs-actors/v2/actors/builtin"
+	market2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/market"
+	miner2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/miner"
+	power2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/power"
+	states2 "github.com/filecoin-project/specs-actors/v2/actors/states"
+	adt2 "github.com/filecoin-project/specs-actors/v2/actors/util/adt"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"github.com/libp2p/go-libp2p-core/peer"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+)
+
+// MinerState reads a storage miner actor's state independent of its actors
+// version.
+type MinerState interface {
+	LockedFunds() abi.TokenAmount
+	// InitialPledge is the v0 InitialPledgeRequirement or v2 InitialPledge.
+	InitialPledge() abi.TokenAmount
+	PreCommitDeposits() abi.TokenAmount
+	// FeeDebt is always zero before actors v2.
+	FeeDebt() abi.TokenAmount
+	// AvailableBalance returns balance less locked funds, precommit
+	// deposits, initial pledge and fee debt.  It is negative for miners in
+	// debt.
+	AvailableBalance(balance abi.TokenAmount) abi.TokenAmount
+	// SectorCount returns the number of sectors in the sectors AMT.
+	SectorCount() (uint64, error)
+	// LoadSectors loads the sectors AMT.
+	LoadSectors() (MinerSectors, error)
+	// ForEachPartition calls cb with every partition of every deadline in
+	// deadline and partition order.
+	ForEachPartition(cb func(dlIdx, partIdx uint64, part *Partition) error) error
+	Info() (*MinerInfo, error)
+	// PreCommitCount returns the number of precommitted sectors.
+	PreCommitCount() (uint64, error)
+	// VestingFunds returns the vesting table in epoch order.
+	VestingFunds() ([]miner2.VestingFund, error)
+	// NextDeadline returns the next deadline to be processed by cron.  Its
+	// CurrentEpoch is not set.
+	NextDeadline() *dline.Info
+}
+
+// MinerInfo is the static information of a miner common to all actors
+// versions.
+type MinerInfo struct {
+	Owner                      address.Address
+	Worker                     address.Address
+	ControlAddresses           []address.Address
+	PeerID                     peer.ID
+	SealProofType              abi.RegisteredSealProof
+	SectorSize                 abi.SectorSize
+	WindowPoStPartitionSectors uint64
+}
+
+// MinerSectors reads a miner's sectors.  Sectors of every version are
+// returned as v2 infos, v0 sectors have no replaced sector age and day
+// reward.
+type MinerSectors interface {
+	Get(num abi.SectorNumber) (*miner2.SectorOnChainInfo, bool, error)
+}
+
+// Partition holds the sector sets of a deadline partition.  Unproven is
+// empty before actors v2.
+type Partition struct {
+	Sectors    bitfield.BitField
+	Unproven   bitfield.BitField
+	Faults     bitfield.BitField
+	Recoveries bitfield.BitField
+	Terminated bitfield.BitField
+}
+
+// PowerClaim is a miner's power as claimed in the power actor.
+type PowerClaim struct {
+	RawBytePower    abi.StoragePower
+	QualityAdjPower abi.StoragePower
+}
+
+// PowerState reads the storage power actor's state independent of its
+// actors version.
+type PowerState interface {
+	TotalPower() PowerClaim
+	TotalCommitted() PowerClaim
+	MinerCount() int64
+	MinerAboveMinPowerCount() int64
+	ForEachClaim(cb func(miner address.Address, claim PowerClaim) error) error
+	HAMTs() []StateHAMT
+}
+
+// MarketState reads the storage market actor's state independent of its
+// actors version.
+type MarketState interface {
+	NextID() abi.DealID
+	ProposalCount() (uint64, error)
+	DealStateCount() (uint64, error)
+	// TotalLocked is the sum of locked client and provider collateral and
+	// client storage fees.
+	TotalLocked() abi.TokenAmount
+	HAMTs() []StateHAMT
+}
+
+// StateHAMT is a HAMT in an actor's state, named "<actor type>.<field>".
+type StateHAMT struct {
+	Name string
+	Root cid.Cid
+}
+
+// IsMinerActor returns true if code is a storage miner actor code of any
+// actors version.
+func IsMinerActor(code cid.Cid) bool {
+	return code.Equals(builtin0.StorageMinerActorCodeID) || code.Equals(builtin2.StorageMinerActorCodeID)
+}
+
+// LoadMinerState loads the state of a storage miner actor of any actors
+// version.
+func LoadMinerState(ctx context.Context, store cbornode.IpldStore, a *states2.Actor) (MinerState, error) {
+	switch {
+	case a.Code.Equals(builtin0.StorageMinerActorCodeID):
+		st := minerState0{store: adt0.WrapStore(ctx, store)}
+		if err := store.Get(ctx, a.Head, &st.st); err != nil {
+			return nil, xerrors.Errorf("failed to load miner state %s: %w", a.Head, err)
+		}
+		return &st, nil
+	case a.Code.Equals(builtin2.StorageMinerActorCodeID):
+		st := minerState2{store: adt2.WrapStore(ctx, store)}
+		if err := store.Get(ctx, a.Head, &st.st); err != nil {
+			return nil, xerrors.Errorf("failed to load miner state %s: %w", a.Head, err)
+		}
+		return &st, nil
+	}
+	return nil, xerrors.Errorf("actor code %s is not a miner", a.Code)
+}
+
+// LoadPowerState loads the state of the storage power actor of any actors
+// version.
+func LoadPowerState(ctx context.Context, store cbornode.IpldStore, a *states2.Actor) (PowerState, error) {
+	switch {
+	case a.Code.Equals(builtin0.StoragePowerActorCodeID):
+		st := powerState0{store: adt0.WrapStore(ctx, store)}
+		if err := store.Get(ctx, a.Head, &st.st); err != nil {
+			return nil, xerrors.Errorf("failed to load power state %s: %w", a.Head, err)
+		}
+		return &st, nil
+	case a.Code.Equals(builtin2.StoragePowerActorCodeID):
+		st := powerState2{store: adt2.WrapStore(ctx, store)}
+		if err := store.Get(ctx, a.Head, &st.st); err != nil {
+			return nil, xerrors.Errorf("failed to load power state %s: %w", a.Head, err)
+		}
+		return &st, nil
+	}
+	return nil, xerrors.Errorf("actor code %s is not the power actor", a.Code)
+}
+
+// LoadMarketState loads the state of the storage market actor of any actors
+// version.
+func LoadMarketState(ctx context.Context, store cbornode.IpldStore, a *states2.Actor) (MarketState, error) {
+	switch {
+	case a.Code.Equals(builtin0.StorageMarketActorCodeID):
+		st := marketState0{store: adt0.WrapStore(ctx, store)}
+		if err := store.Get(ctx, a.Head, &st.st); err != nil {
+			return nil, xerrors.Errorf("failed to load market state %s: %w", a.Head, err)
+		}
+		return &st, nil
+	case a.Code.Equals(builtin2.StorageMarketActorCodeID):
+		st := marketState2{store: adt2.WrapStore(ctx, store)}
+		if err := store.Get(ctx, a.Head, &st.st); err != nil {
+			return nil, xerrors.Errorf("failed to load market state %s: %w", a.Head, err)
+		}
+		return &st, nil
+	}
+	return nil, xerrors.Errorf("actor code %s is not the market actor", a.Code)
+}
+
+func getSingleton(tree *states2.Tree, addr address.Address) (*states2.Actor, error) {
+	a, found, err := tree.GetActor(addr)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, xerrors.Errorf("actor %s not found", addr)
+	}
+	return a, nil
+}
+
+// LoadTreePowerState loads the power actor state of tree.
+func LoadTreePowerState(ctx context.Context, store cbornode.IpldStore, tree *states2.Tree) (PowerState, error) {
+	a, err := getSingleton(tree, builtin2.StoragePowerActorAddr)
+	if err != nil {
+		return nil, err
+	}
+	return LoadPowerState(ctx, store, a)
+}
+
+// LoadTreeMarketState loads the market actor state of tree.
+func LoadTreeMarketState(ctx context.Context, store cbornode.IpldStore, tree *states2.Tree) (MarketState, error) {
+	a, err := getSingleton(tree, builtin2.StorageMarketActorAddr)
+	if err != nil {
+		return nil, err
+	}
+	return LoadMarketState(ctx, store, a)
+}
+
+type minerState0 struct {
+	st    miner0.State
+	store adt0.Store
+}
+
+func (s *minerState0) LockedFunds() abi.TokenAmount       { return s.st.LockedFunds }
+func (s *minerState0) InitialPledge() abi.TokenAmount     { return s.st.InitialPledgeRequirement }
+func (s *minerState0) PreCommitDeposits() abi.TokenAmount { return s.st.PreCommitDeposits }
+func (s *minerState0) FeeDebt() abi.TokenAmount           { return big.Zero() }
+
+func (s *minerState0) AvailableBalance(balance abi.TokenAmount) abi.TokenAmount {
+	return big.Sub(balance, big.Sum(s.st.LockedFunds, s.st.PreCommitDeposits, s.st.InitialPledgeRequirement))
+}
+
+func (s *minerState0) SectorCount() (uint64, error) {
+	sectors, err := adt0.AsArray(s.store, s.st.Sectors)
+	if err != nil {
+		return 0, err
+	}
+	return sectors.Length(), nil
+}
+
+func (s *minerState0) LoadSectors() (MinerSectors, error) {
+	sectors, err := miner0.LoadSectors(s.store, s.st.Sectors)
+	if err != nil {
+		return nil, err
+	}
+	return minerSectors0{sectors}, nil
+}
+
+func (s *minerState0) ForEachPartition(cb func(dlIdx, partIdx uint64, part *Partition) error) error {
+	deadlines, err := s.st.LoadDeadlines(s.store)
+	if err != nil {
+		return err
+	}
+	return deadlines.ForEach(s.store, func(dlIdx uint64, dl *miner0.Deadline) error {
+		partitions, err := dl.PartitionsArray(s.store)
+		if err != nil {
+			return err
+		}
+		var partition miner0.Partition
+		return partitions.ForEach(&partition, func(i int64) error {
+			return cb(dlIdx, uint64(i), &Partition{
+				Sectors:    partition.Sectors,
+				Unproven:   bitfield.New(),
+				Faults:     partition.Faults,
+				Recoveries: partition.Recoveries,
+				Terminated: partition.Terminated,
+			})
+		})
+	})
+}
+
+func (s *minerState0) Info() (*MinerInfo, error) {
+	info, err := s.st.GetInfo(s.store)
+	if err != nil {
+		return nil, err
+	}
+	return &MinerInfo{
+		Owner:                      info.Owner,
+		Worker:                     info.Worker,
+		ControlAddresses:           info.ControlAddresses,
+		PeerID:                     peer.ID(info.PeerId),
+		SealProofType:              info.SealProofType,
+		SectorSize:                 info.SectorSize,
+		WindowPoStPartitionSectors: info.WindowPoStPartitionSectors,
+	}, nil
+}
+
+func (s *minerState0) PreCommitCount() (uint64, error) {
+	return countHAMT0(s.store, s.st.PreCommittedSectors)
+}
+
+func (s *minerState0) VestingFunds() ([]miner2.VestingFund, error) {
+	vesting, err := s.st.LoadVestingFunds(s.store)
+	if err != nil {
+		return nil, err
+	}
+	funds := make([]miner2.VestingFund, len(vesting.Funds))
+	for i, f := range vesting.Funds {
+		funds[i] = miner2.VestingFund{Epoch: f.Epoch, Amount: f.Amount}
+	}
+	return funds, nil
+}
+
+func (s *minerState0) NextDeadline() *dline.Info {
+	return miner0.NewDeadlineInfo(s.st.ProvingPeriodStart, s.st.CurrentDeadline, 0)
+}
+
+type minerSectors0 struct {
+	miner0.Sectors
+}
+
+func (s minerSectors0) Get(num abi.SectorNumber) (*miner2.SectorOnChainInfo, bool, error) {
+	info, found, err := s.Sectors.Get(num)
+	if err != nil || !found {
+		return nil, found, err
+	}
+	return &miner2.SectorOnChainInfo{
+		SectorNumber:          info.SectorNumber,
+		SealProof:             info.SealProof,
+		SealedCID:             info.SealedCID,
+		DealIDs:               info.DealIDs,
+		Activation:            info.Activation,
+		Expiration:            info.Expiration,
+		DealWeight:            info.DealWeight,
+		VerifiedDealWeight:    info.VerifiedDealWeight,
+		InitialPledge:         info.InitialPledge,
+		ExpectedDayReward:     info.ExpectedDayReward,
+		ExpectedStoragePledge: info.ExpectedStoragePledge,
+		ReplacedDayReward:     big.Zero(),
+	}, true, nil
+}
+
+type minerState2 struct {
+	st    miner2.State
+	store adt2.Store
+}
+
+func (s *minerState2) LockedFunds() abi.TokenAmount       { return s.st.LockedFunds }
+func (s *minerState2) InitialPledge() abi.TokenAmount     { return s.st.InitialPledge }
+func (s *minerState2) PreCommitDeposits() abi.TokenAmount { return s.st.PreCommitDeposits }
+func (s *minerState2) FeeDebt() abi.TokenAmount           { return s.st.FeeDebt }
+
+func (s *minerState2) AvailableBalance(balance abi.TokenAmount) abi.TokenAmount {
+	// miner2.State.GetAvailableBalance errors rather than going negative
+	return big.Sub(balance, big.Sum(s.st.LockedFunds, s.st.PreCommitDeposits, s.st.InitialPledge, s.st.FeeDebt))
+}
+
+func (s *minerState2) SectorCount() (uint64, error) {
+	sectors, err := adt2.AsArray(s.store, s.st.Sectors)
+	if err != nil {
+		return 0, err
+	}
+	return sectors.Length(), nil
+}
+
+func (s *minerState2) LoadSectors() (MinerSectors, error) {
+	sectors, err := miner2.LoadSectors(s.store, s.st.Sectors)
+	if err != nil {
+		return nil, err
+	}
+	return sectors, nil
+}
+
+func (s *minerState2) ForEachPartition(cb func(dlIdx, partIdx uint64, part *Partition) error) error {
+	deadlines, err := s.st.LoadDeadlines(s.store)
+	if err != nil {
+		return err
+	}
+	return deadlines.ForEach(s.store, func(dlIdx uint64, dl *miner2.Deadline) error {
+		partitions, err := dl.PartitionsArray(s.store)
+		if err != nil {
+			return err
+		}
+		var partition miner2.Partition
+		return partitions.ForEach(&partition, func(i int64) error {
+			return cb(dlIdx, uint64(i), &Partition{
+				Sectors:    partition.Sectors,
+				Unproven:   partition.Unproven,
+				Faults:     partition.Faults,
+				Recoveries: partition.Recoveries,
+				Terminated: partition.Terminated,
+			})
+		})
+	})
+}
+
+func (s *minerState2) Info() (*MinerInfo, error) {
+	info, err := s.st.GetInfo(s.store)
+	if err != nil {
+		return nil, err
+	}
+	return &MinerInfo{
+		Owner:                      info.Owner,
+		Worker:                     info.Worker,
+		ControlAddresses:           info.ControlAddresses,
+		PeerID:                     peer.ID(info.PeerId),
+		SealProofType:              info.SealProofType,
+		SectorSize:                 info.SectorSize,
+		WindowPoStPartitionSectors: info.WindowPoStPartitionSectors,
+	}, nil
+}
+
+func (s *minerState2) PreCommitCount() (uint64, error) {
+	return countHAMT2(s.store, s.st.PreCommittedSectors)
+}
+
+func (s *minerState2) VestingFunds() ([]miner2.VestingFund, error) {
+	vesting, err := s.st.LoadVestingFunds(s.store)
+	if err != nil {
+		return nil, err
+	}
+	return vesting.Funds, nil
+}
+
+func (s *minerState2) NextDeadline() *dline.Info {
+	return miner2.NewDeadlineInfo(s.st.ProvingPeriodStart, s.st.CurrentDeadline, 0)
+}
+
+func countHAMT0(store adt0.Store, root cid.Cid) (uint64, error) {
+	m, err := adt0.AsMap(store, root)
+	if err != nil {
+		return 0, err
+	}
+	var count uint64
+	var d cbg.Deferred
+	err = m.ForEach(&d, func(string) error {
+		count++
+		return nil
+	})
+	return count, err
+}
+
+func countHAMT2(store adt2.Store, root cid.Cid) (uint64, error) {
+	m, err := adt2.AsMap(store, root)
+	if err != nil {
+		return 0, err
+	}
+	var count uint64
+	var d cbg.Deferred
+	err = m.ForEach(&d, func(string) error {
+		count++
+		return nil
+	})
+	return count, err
+}
+
+type powerState0 struct {
+	st    power0.State
+	store adt0.Store
+}
+
+func (s *powerState0) TotalPower() PowerClaim {
+	return PowerClaim{s.st.TotalRawBytePower, s.st.TotalQualityAdjPower}
+}
+
+func (s *powerState0) TotalCommitted() PowerClaim {
+	return PowerClaim{s.st.TotalBytesCommitted, s.st.TotalQABytesCommitted}
+}
+
+func (s *powerState0) MinerCount() int64              { return s.st.MinerCount }
+func (s *powerState0) MinerAboveMinPowerCount() int64 { return s.st.MinerAboveMinPowerCount }
+
+func (s *powerState0) ForEachClaim(cb func(miner address.Address, claim PowerClaim) error) error {
+	claims, err := adt0.AsMap(s.store, s.st.Claims)
+	if err != nil {
+		return err
+	}
+	var claim power0.Claim
+	return claims.ForEach(&claim, func(key string) error {
+		addr, err := address.NewFromBytes([]byte(key))
+		if err != nil {
+			return err
+		}
+		return cb(addr, PowerClaim{claim.RawBytePower, claim.QualityAdjPower})
+	})
+}
+
+func (s *powerState0) HAMTs() []StateHAMT {
+	return powerHAMTs(s.st.CronEventQueue, s.st.Claims, s.st.ProofValidationBatch)
+}
+
+type powerState2 struct {
+	st    power2.State
+	store adt2.Store
+}
+
+func (s *powerState2) TotalPower() PowerClaim {
+	return PowerClaim{s.st.TotalRawBytePower, s.st.TotalQualityAdjPower}
+}
+
+func (s *powerState2) TotalCommitted() PowerClaim {
+	return PowerClaim{s.st.TotalBytesCommitted, s.st.TotalQABytesCommitted}
+}
+
+func (s *powerState2) MinerCount() int64              { return s.st.MinerCount }
+func (s *powerState2) MinerAboveMinPowerCount() int64 { return s.st.MinerAboveMinPowerCount }
+
+func (s *powerState2) ForEachClaim(cb func(miner address.Address, claim PowerClaim) error) error {
+	claims, err := adt2.AsMap(s.store, s.st.Claims)
+	if err != nil {
+		return err
+	}
+	var claim power2.Claim
+	return claims.ForEach(&claim, func(key string) error {
+		addr, err := address.NewFromBytes([]byte(key))
+		if err != nil {
+			return err
+		}
+		return cb(addr, PowerClaim{claim.RawBytePower, claim.QualityAdjPower})
+	})
+}
+
+func (s *powerState2) HAMTs() []StateHAMT {
+	return powerHAMTs(s.st.CronEventQueue, s.st.Claims, s.st.ProofValidationBatch)
+}
+
+func powerHAMTs(cronEventQueue, claims cid.Cid, proofValidationBatch *cid.Cid) []StateHAMT {
+	hamts := []StateHAMT{
+		{"power.CronEventQueue", cronEventQueue},
+		{"power.Claims", claims},
+	}
+	if proofValidationBatch != nil {
+		hamts = append(hamts, StateHAMT{"power.ProofValidationBatch", *proofValidationBatch})
+	}
+	return hamts
+}
+
+type marketState0 struct {
+	st    market0.State
+	store adt0.Store
+}
+
+func (s *marketState0) NextID() abi.DealID { return s.st.NextID }
+
+func (s *marketState0) ProposalCount() (uint64, error) {
+	proposals, err := adt0.AsArray(s.store, s.st.Proposals)
+	if err != nil {
+		return 0, err
+	}
+	return proposals.Length(), nil
+}
+
+func (s *marketState0) DealStateCount() (uint64, error) {
+	dealStates, err := adt0.AsArray(s.store, s.st.States)
+	if err != nil {
+		return 0, err
+	}
+	return dealStates.Length(), nil
+}
+
+func (s *marketState0) TotalLocked() abi.TokenAmount {
+	return big.Sum(s.st.TotalClientLockedCollateral, s.st.TotalProviderLockedCollateral, s.st.TotalClientStorageFee)
+}
+
+func (s *marketState0) HAMTs() []StateHAMT {
+	return marketHAMTs(s.st.PendingProposals, s.st.EscrowTable, s.st.LockedTable, s.st.DealOpsByEpoch)
+}
+
+type marketState2 struct {
+	st    market2.State
+	store adt2.Store
+}
+
+func (s *marketState2) NextID() abi.DealID { return s.st.NextID }
+
+func (s *marketState2) ProposalCount() (uint64, error) {
+	proposals, err := adt2.AsArray(s.store, s.st.Proposals)
+	if err != nil {
+		return 0, err
+	}
+	return proposals.Length(), nil
+}
+
+func (s *marketState2) DealStateCount() (uint64, error) {
+	dealStates, err := adt2.AsArray(s.store, s.st.States)
+	if err != nil {
+		return 0, err
+	}
+	return dealStates.Length(), nil
+}
+
+func (s *marketState2) TotalLocked() abi.TokenAmount {
+	return big.Sum(s.st.TotalClientLockedCollateral, s.st.TotalProviderLockedCollateral, s.st.TotalClientStorageFee)
+}
+
+func (s *marketState2) HAMTs() []StateHAMT {
+	return marketHAMTs(s.st.PendingProposals, s.st.EscrowTable, s.st.LockedTable, s.st.DealOpsByEpoch)
+}
+
+func marketHAMTs(pendingProposals, escrowTable, lockedTable, dealOpsByEpoch cid.Cid) []StateHAMT {
+	return []StateHAMT{
+		{"market.PendingProposals", pendingProposals},
+		{"market.EscrowTable", escrowTable},
+		{"market.LockedTable", lockedTable},
+		{"market.DealOpsByEpoch", dealOpsByEpoch},
+	}
+}
@@ -0,0 +1,286 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	address "github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	builtin0 "github.com/filecoin-project/specs-actors/actors/builtin"
+	builtin2 "github.com/filecoin-project/specs-actors/v2/actors/builtin"
+	states2 "github.com/filecoin-project/specs-actors/v2/actors/states"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"golang.org/x/xerrors"
+)
+
+// AuditDiscrepancy is a value not conserved by a migration.  Address is
+// address.Undef for discrepancies of the whole tree.
+type AuditDiscrepancy struct {
+	Address address.Address
+	Message string
+}
+
+// AuditReport is the result of comparing a v0 migration input with its v2
+// output.
+type AuditReport struct {
+	StateRootIn  cid.Cid
+	StateRootOut cid.Cid
+	ActorsIn     int
+	ActorsOut    int
+	BalanceIn    abi.TokenAmount
+	BalanceOut   abi.TokenAmount
+	// BurntFundsIn is the burnt funds balance of the input.  The migration
+	// pays MinerDebt, the sum of all negative miner available balances, out
+	// of it.
+	BurntFundsIn  abi.TokenAmount
+	MinerDebt     abi.TokenAmount
+	Discrepancies []AuditDiscrepancy
+}
+
+// AuditMigration compares the v0 actors tree at stateRootIn with the v2
+// actors tree at stateRootOut it was migrated to.  Total FIL, actor
+// addresses, per-actor balances after the migration's repayment of miner
+// debt from burnt funds, power totals and claims, sector counts per miner and
+// market deal counts should all be conserved.  Every difference is reported
+// as a discrepancy sorted by address.
+func AuditMigration(ctx context.Context, store cbornode.IpldStore, stateRootIn, stateRootOut cid.Cid) (*AuditReport, error) {
+	if err := checkVersion(ctx, store, stateRootIn, ActorsVersion0); err != nil {
+		return nil, err
+	}
+	if err := checkVersion(ctx, store, stateRootOut, ActorsVersion2); err != nil {
+		return nil, err
+	}
+	burntFunds, debts, err := TreeMinerDebts(ctx, store, stateRootIn)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to load input miner debts: %w", err)
+	}
+
+	actorsIn, err := collectActors(ctx, store, stateRootIn)
+	if err != nil {
+		return nil, err
+	}
+	actorsOut, err := collectActors(ctx, store, stateRootOut)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &AuditReport{
+		StateRootIn:  stateRootIn,
+		StateRootOut: stateRootOut,
+		ActorsIn:     len(actorsIn),
+		ActorsOut:    len(actorsOut),
+		BalanceIn:    big.Zero(),
+		BalanceOut:   big.Zero(),
+		BurntFundsIn: burntFunds,
+		MinerDebt:    big.Zero(),
+	}
+	addf := func(addr address.Address, format string, args ...interface{}) {
+		report.Discrepancies = append(report.Discrepancies, AuditDiscrepancy{
+			Address: addr,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	// Miners with a negative available balance are topped up from burnt
+	// funds by the migration.
+	for _, debt := range debts {
+		report.MinerDebt = big.Add(report.MinerDebt, debt)
+	}
+
+	for addr, in := range actorsIn {
+		report.BalanceIn = big.Add(report.BalanceIn, in.Balance)
+		out, found := actorsOut[addr]
+		if !found {
+			addf(addr, "actor missing from output")
+			continue
+		}
+		expected := in.Balance
+		if debt, ok := debts[addr]; ok {
+			expected = big.Add(expected, debt)
+		}
+		if addr == builtin0.BurntFundsActorAddr {
+			expected = big.Sub(expected, report.MinerDebt)
+		}
+		if !expected.Equals(out.Balance) {
+			addf(addr, "balance %v, expected %v", out.Balance, expected)
+		}
+		if in.CallSeqNum != out.CallSeqNum {
+			addf(addr, "nonce %d, expected %d", out.CallSeqNum, in.CallSeqNum)
+		}
+
+		if in.Code == builtin0.StorageMinerActorCodeID {
+			sectorsIn, err := minerSectorCount(ctx, store, in)
+			if err != nil {
+				return nil, xerrors.Errorf("failed to count sectors of input miner %v: %w", addr, err)
+			}
+			sectorsOut, err := minerSectorCount(ctx, store, out)
+			if err != nil {
+				return nil, xerrors.Errorf("failed to count sectors of output miner %v: %w", addr, err)
+			}
+			if sectorsIn != sectorsOut {
+				addf(addr, "sector count %d, expected %d", sectorsOut, sectorsIn)
+			}
+		}
+	}
+	for addr, out := range actorsOut {
+		report.BalanceOut = big.Add(report.BalanceOut, out.Balance)
+		if _, found := actorsIn[addr]; !found {
+			addf(addr, "actor added by migration")
+		}
+	}
+	if !report.BalanceIn.Equals(report.BalanceOut) {
+		addf(address.Undef, "total balance %v, expected %v", report.BalanceOut, report.BalanceIn)
+	}
+
+	if err := auditPower(ctx, store, actorsIn, actorsOut, addf); err != nil {
+		return nil, err
+	}
+	if err := auditMarket(ctx, store, actorsIn, actorsOut, addf); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(report.Discrepancies, func(i, j int) bool {
+		return report.Discrepancies[i].Address.String() < report.Discrepancies[j].Address.String()
+	})
+	return report, nil
+}
+
+func collectActors(ctx context.Context, store cbornode.IpldStore, stateRoot cid.Cid) (map[address.Address]*states2.Actor, error) {
+	actors := make(map[address.Address]*states2.Actor)
+	if err := ForEachActor(ctx, store, stateRoot, func(addr address.Address, a *states2.Actor) error {
+		actorCopy := *a
+		actors[addr] = &actorCopy
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return actors, nil
+}
+
+func minerSectorCount(ctx context.Context, store cbornode.IpldStore, a *states2.Actor) (uint64, error) {
+	st, err := LoadMinerState(ctx, store, a)
+	if err != nil {
+		return 0, err
+	}
+	return st.SectorCount()
+}
+
+func auditPower(ctx context.Context, store cbornode.IpldStore, actorsIn, actorsOut map[address.Address]*states2.Actor, addf func(address.Address, string, ...interface{})) error {
+	in, found := actorsIn[builtin0.StoragePowerActorAddr]
+	if !found {
+		return xerrors.Errorf("power actor missing from input")
+	}
+	out, found := actorsOut[builtin2.StoragePowerActorAddr]
+	if !found {
+		return xerrors.Errorf("power actor missing from output")
+	}
+	before, err := LoadPowerState(ctx, store, in)
+	if err != nil {
+		return xerrors.Errorf("failed to load input power state: %w", err)
+	}
+	after, err := LoadPowerState(ctx, store, out)
+	if err != nil {
+		return xerrors.Errorf("failed to load output power state: %w", err)
+	}
+
+	addr := builtin0.StoragePowerActorAddr
+	compare := func(name string, got, expected abi.StoragePower) {
+		if !got.Equals(expected) {
+			addf(addr, "%s %v, expected %v", name, got, expected)
+		}
+	}
+	compare("total raw byte power", after.TotalPower().RawBytePower, before.TotalPower().RawBytePower)
+	compare("total quality adjusted power", after.TotalPower().QualityAdjPower, before.TotalPower().QualityAdjPower)
+	compare("total bytes committed", after.TotalCommitted().RawBytePower, before.TotalCommitted().RawBytePower)
+	compare("total quality adjusted bytes committed", after.TotalCommitted().QualityAdjPower, before.TotalCommitted().QualityAdjPower)
+	if after.MinerCount() != before.MinerCount() {
+		addf(addr, "miner count %d, expected %d", after.MinerCount(), before.MinerCount())
+	}
+	if after.MinerAboveMinPowerCount() != before.MinerAboveMinPowerCount() {
+		addf(addr, "miners above min power %d, expected %d", after.MinerAboveMinPowerCount(), before.MinerAboveMinPowerCount())
+	}
+
+	claimsIn, err := collectClaims(before)
+	if err != nil {
+		return xerrors.Errorf("failed to load input power claims: %w", err)
+	}
+	claimsOut, err := collectClaims(after)
+	if err != nil {
+		return xerrors.Errorf("failed to load output power claims: %w", err)
+	}
+	for miner, claimIn := range claimsIn {
+		claimOut, found := claimsOut[miner]
+		if !found {
+			addf(miner, "power claim missing from output")
+			continue
+		}
+		if !claimIn.RawBytePower.Equals(claimOut.RawBytePower) || !claimIn.QualityAdjPower.Equals(claimOut.QualityAdjPower) {
+			addf(miner, "power claim raw %v qa %v, expected raw %v qa %v", claimOut.RawBytePower, claimOut.QualityAdjPower, claimIn.RawBytePower, claimIn.QualityAdjPower)
+		}
+	}
+	for miner := range claimsOut {
+		if _, found := claimsIn[miner]; !found {
+			addf(miner, "power claim added by migration")
+		}
+	}
+	return nil
+}
+
+func collectClaims(st PowerState) (map[address.Address]PowerClaim, error) {
+	claims := make(map[address.Address]PowerClaim)
+	err := st.ForEachClaim(func(miner address.Address, claim PowerClaim) error {
+		claims[miner] = claim
+		return nil
+	})
+	return claims, err
+}
+
+func auditMarket(ctx context.Context, store cbornode.IpldStore, actorsIn, actorsOut map[address.Address]*states2.Actor, addf func(address.Address, string, ...interface{})) error {
+	in, found := actorsIn[builtin0.StorageMarketActorAddr]
+	if !found {
+		return xerrors.Errorf("market actor missing from input")
+	}
+	out, found := actorsOut[builtin2.StorageMarketActorAddr]
+	if !found {
+		return xerrors.Errorf("market actor missing from output")
+	}
+	before, err := LoadMarketState(ctx, store, in)
+	if err != nil {
+		return xerrors.Errorf("failed to load input market state: %w", err)
+	}
+	after, err := LoadMarketState(ctx, store, out)
+	if err != nil {
+		return xerrors.Errorf("failed to load output market state: %w", err)
+	}
+	proposalsIn, err := before.ProposalCount()
+	if err != nil {
+		return err
+	}
+	dealStatesIn, err := before.DealStateCount()
+	if err != nil {
+		return err
+	}
+	proposalsOut, err := after.ProposalCount()
+	if err != nil {
+		return err
+	}
+	dealStatesOut, err := after.DealStateCount()
+	if err != nil {
+		return err
+	}
+
+	addr := builtin0.StorageMarketActorAddr
+	if proposalsIn != proposalsOut {
+		addf(addr, "deal proposal count %d, expected %d", proposalsOut, proposalsIn)
+	}
+	if dealStatesIn != dealStatesOut {
+		addf(addr, "deal state count %d, expected %d", dealStatesOut, dealStatesIn)
+	}
+	if before.NextID() != after.NextID() {
+		addf(addr, "next deal ID %d, expected %d", after.NextID(), before.NextID())
+	}
+	return nil
+}
@@ -5,10 +5,9 @@ import (
 
 	address "github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
-	"github.com/filecoin-project/lotus/chain/actors/adt"
-	builtin0 "github.com/filecoin-project/specs-actors/actors/builtin"
-	miner0 "github.com/filecoin-project/specs-actors/actors/builtin/miner"
-	states0 "github.com/filecoin-project/specs-actors/actors/states"
+	"github.com/filecoin-project/go-state-types/big"
+	builtin2 "github.com/filecoin-project/specs-actors/v2/actors/builtin"
+	states2 "github.com/filecoin-project/specs-actors/v2/actors/states"
 	cid "github.com/ipfs/go-cid"
 	cbornode "github.com/ipfs/go-ipld-cbor"
 )
@@ -18,35 +17,65 @@ type BalanceInfo struct {
 	LockedFunds       abi.TokenAmount
 	InitialPledge     abi.TokenAmount
 	PreCommitDeposits abi.TokenAmount
+	FeeDebt           abi.TokenAmount
+	// Available is the balance less all of the above, negative for miners
+	// in debt.
+	Available abi.TokenAmount
 }
 
-// V0TreeMinerBalancse returns a map of every miner's balance info
-// at the provided state tree.  It is used for displaying and validating miner
-// info.
-func V0TreeMinerBalances(ctx context.Context, store cbornode.IpldStore, stateRootIn cid.Cid) (map[address.Address]BalanceInfo, error) {
-	adtStore := adt.WrapStore(ctx, store)
-	actorsIn, err := states0.LoadTree(adtStore, stateRootIn)
-	if err != nil {
-		return nil, err
-	}
+// TreeMinerBalances returns a map of every miner's balance info
+// at the provided actors tree of any actors version.  It is used for
+// displaying and validating miner info.
+func TreeMinerBalances(ctx context.Context, store cbornode.IpldStore, actorsRoot cid.Cid) (map[address.Address]BalanceInfo, error) {
 	balances := make(map[address.Address]BalanceInfo)
-
-	err = actorsIn.ForEach(func(addr address.Address, a *states0.Actor) error {
-		if !a.Code.Equals(builtin0.StorageMinerActorCodeID) {
+	err := ForEachActor(ctx, store, actorsRoot, func(addr address.Address, a *states2.Actor) error {
+		if !IsMinerActor(a.Code) {
 			return nil
 		}
-		var inState miner0.State
-		if err := store.Get(ctx, a.Head, &inState); err != nil {
+		st, err := LoadMinerState(ctx, store, a)
+		if err != nil {
 			return err
 		}
-		balance := BalanceInfo{
+		balances[addr] = BalanceInfo{
 			Balance:           a.Balance,
-			LockedFunds:       inState.LockedFunds,
-			InitialPledge:     inState.InitialPledgeRequirement,
-			PreCommitDeposits: inState.PreCommitDeposits,
+			LockedFunds:       st.LockedFunds(),
+			InitialPledge:     st.InitialPledge(),
+			PreCommitDeposits: st.PreCommitDeposits(),
+			FeeDebt:           st.FeeDebt(),
+			Available:         st.AvailableBalance(a.Balance),
 		}
-		balances[addr] = balance
 		return nil
 	})
 	return balances, err
 }
+
+// V0TreeMinerBalances returns a map of every miner's balance info at the
+// provided v0 actors tree.  It is kept for existing callers, use
+// TreeMinerBalances for trees of any actors version.
+func V0TreeMinerBalances(ctx context.Context, store cbornode.IpldStore, stateRootIn cid.Cid) (map[address.Address]BalanceInfo, error) {
+	if err := checkVersion(ctx, store, stateRootIn, ActorsVersion0); err != nil {
+		return nil, err
+	}
+	return TreeMinerBalances(ctx, store, stateRootIn)
+}
+
+// TreeMinerDebts returns the burnt funds balance and the debt of every miner
+// whose available balance is negative in the actors tree at actorsRoot.
+// These are the debts the v2 migration pays off from burnt funds.
+func TreeMinerDebts(ctx context.Context, store cbornode.IpldStore, actorsRoot cid.Cid) (abi.TokenAmount, map[address.Address]abi.TokenAmount, error) {
+	burnt, err := LoadActor(ctx, store, actorsRoot, builtin2.BurntFundsActorAddr)
+	if err != nil {
+		return big.Zero(), nil, err
+	}
+	balances, err := TreeMinerBalances(ctx, store, actorsRoot)
+	if err != nil {
+		return big.Zero(), nil, err
+	}
+	debts := make(map[address.Address]abi.TokenAmount)
+	for addr, bi := range balances {
+		if bi.Available.LessThan(big.Zero()) {
+			debts[addr] = bi.Available.Neg()
+		}
+	}
+	return burnt.Balance, debts, nil
+}
@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	cid "github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+)
+
+// DurationStats summarizes repeated measurements of the same operation.
+type DurationStats struct {
+	Mean   time.Duration
+	Stddev time.Duration
+	P50    time.Duration
+	P95    time.Duration
+	Min    time.Duration
+	Max    time.Duration
+}
+
+// NewDurationStats computes stats over at least one sample.
+func NewDurationStats(samples []time.Duration) DurationStats {
+	sorted := make([]time.Duration, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var sum float64
+	for _, d := range sorted {
+		sum += float64(d)
+	}
+	mean := sum / float64(len(sorted))
+	var variance float64
+	if len(sorted) > 1 {
+		for _, d := range sorted {
+			variance += (float64(d) - mean) * (float64(d) - mean)
+		}
+		variance /= float64(len(sorted) - 1)
+	}
+
+	return DurationStats{
+		Mean:   time.Duration(mean),
+		Stddev: time.Duration(math.Sqrt(variance)),
+		P50:    percentile(sorted, 50),
+		P95:    percentile(sorted, 95),
+		Min:    sorted[0],
+		Max:    sorted[len(sorted)-1],
+	}
+}
+
+// percentile returns the nearest rank percentile of ascending samples.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := int(math.Ceil(float64(p)/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// BenchResult records a repeated-run migration benchmark.
+type BenchResult struct {
+	StateRoot cid.Cid
+	Epoch     abi.ChainEpoch
+	Migration string
+	Runs      int
+	Cold      bool
+	Migrate   DurationStats
+	Flush     DurationStats
+}
+
+// LoadBenchResult reads a benchmark result saved with WriteBenchResult.
+func LoadBenchResult(path string) (*BenchResult, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var result BenchResult
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, xerrors.Errorf("failed to decode benchmark result %s: %w", path, err)
+	}
+	return &result, nil
+}
+
+// WriteBenchResult writes a benchmark result to path as indented JSON.
+func WriteBenchResult(path string, result *BenchResult) error {
+	raw, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(raw, '\n'), 0644)
+}
+
+// CompareBench returns a description of every mean and p95 time of current
+// exceeding the same time of baseline by more than tolerance, a fraction.
+func CompareBench(baseline, current *BenchResult, tolerance float64) []string {
+	var regressions []string
+	check := func(name string, base, cur time.Duration) {
+		if base <= 0 {
+			return
+		}
+		if float64(cur) > float64(base)*(1+tolerance) {
+			regressions = append(regressions, fmt.Sprintf("%s: %v => %v (+%.1f%%)",
+				name, base, cur, 100*(float64(cur)-float64(base))/float64(base)))
+		}
+	}
+	check("migrate mean", baseline.Migrate.Mean, current.Migrate.Mean)
+	check("migrate p95", baseline.Migrate.P95, current.Migrate.P95)
+	check("flush mean", baseline.Flush.Mean, current.Flush.Mean)
+	check("flush p95", baseline.Flush.P95, current.Flush.P95)
+	return regressions
+}
@@ -136,6 +136,16 @@ func (rb *BufferedBlockstore) LoadToReadOnlyBuffer(ctx context.Context, c cid.Ci
 	return lvm.Copy(ctx, rb.read, rb.roBuffer, c)
 }
 
+// DiscardBuffer drops all writes buffered in memory.
+func (rb *BufferedBlockstore) DiscardBuffer() {
+	rb.buffer = lbstore.NewTemporarySync()
+}
+
+// DiscardReadOnlyBuffer drops all blocks preloaded into the read only buffer.
+func (rb *BufferedBlockstore) DiscardReadOnlyBuffer() {
+	rb.roBuffer = lbstore.NewTemporary()
+}
+
 func (rb *BufferedBlockstore) FlushFromBuffer(ctx context.Context, c cid.Cid) error {
 	allCh, err := rb.buffer.AllKeysChan(ctx)
 	if err != nil {
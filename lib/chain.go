@@ -68,6 +68,27 @@ func (c *Chain) FlushBufferedState(ctx context.Context, stateRoot cid.Cid) error
 	return bs.FlushFromBuffer(ctx, stateRoot)
 }
 
+// DiscardBufferedState drops all state written since loading the chain, or
+// since the last discard, without flushing it.
+func (c *Chain) DiscardBufferedState(ctx context.Context) error {
+	bs, err := c.loadBufferedBstore(ctx)
+	if err != nil {
+		return err
+	}
+	bs.DiscardBuffer()
+	return nil
+}
+
+// DiscardReadOnlyBuffer drops all state preloaded with LoadToReadOnlyBuffer.
+func (c *Chain) DiscardReadOnlyBuffer(ctx context.Context) error {
+	bs, err := c.loadBufferedBstore(ctx)
+	if err != nil {
+		return err
+	}
+	bs.DiscardReadOnlyBuffer()
+	return nil
+}
+
 // ChainStateIterator moves from tip to genesis emiting parent state roots of blocks
 type ChainStateIterator struct {
 	bs         blockstore.Blockstore
@@ -0,0 +1,136 @@
+package lib
+
+import (
+	"context"
+	"sort"
+
+	address "github.com/filecoin-project/go-address"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+)
+
+// StateBlock is a block of a state tree with the actor type it is first
+// reached from, or "state tree" for the state root and actors HAMT.
+type StateBlock struct {
+	Owner string
+	Size  int
+}
+
+// StateBlocks maps every block reachable from a state root to its owner.
+type StateBlocks map[cid.Cid]StateBlock
+
+// LoadStateBlocks walks every block reachable from the state root of tree,
+// attributing each to an owner as StateSize does.
+func LoadStateBlocks(ctx context.Context, store cbornode.IpldStore, tree *StateTree) (StateBlocks, error) {
+	var treeSize SizeCount
+	w, err := walkStateTree(ctx, store, tree, &treeSize, func(_ address.Address, _ string, _ map[string]*SizeCount) {})
+	if err != nil {
+		return nil, err
+	}
+	return w.blocks, nil
+}
+
+// BlockChurn counts the blocks of a state that are new since the previous
+// state, shared with it, and dropped from it.
+type BlockChurn struct {
+	New     SizeCount
+	Shared  SizeCount
+	Dropped SizeCount
+}
+
+func (c *BlockChurn) merge(o BlockChurn) {
+	for _, p := range [][2]*SizeCount{{&c.New, &o.New}, {&c.Shared, &o.Shared}, {&c.Dropped, &o.Dropped}} {
+		p[0].Blocks += p[1].Blocks
+		p[0].Bytes += p[1].Bytes
+	}
+}
+
+// OwnerChurn is the churn of the blocks of one owner.
+type OwnerChurn struct {
+	Owner string
+	BlockChurn
+}
+
+// StateChurn is the churn between two consecutive state roots.
+type StateChurn struct {
+	Height int64
+	State  cid.Cid
+	BlockChurn
+	// Owners are ordered by new bytes, largest first.
+	Owners []OwnerChurn
+}
+
+// DiffStateBlocks counts the blocks of after that are new or shared with
+// before, attributed to their owners in after, and the blocks of before
+// dropped from after, attributed to their owners in before.
+func DiffStateBlocks(before, after StateBlocks) (BlockChurn, []OwnerChurn) {
+	var total BlockChurn
+	owners := make(map[string]*BlockChurn)
+	owner := func(name string) *BlockChurn {
+		if owners[name] == nil {
+			owners[name] = &BlockChurn{}
+		}
+		return owners[name]
+	}
+	for c, b := range after {
+		if _, found := before[c]; found {
+			total.Shared.add(b.Size)
+			owner(b.Owner).Shared.add(b.Size)
+		} else {
+			total.New.add(b.Size)
+			owner(b.Owner).New.add(b.Size)
+		}
+	}
+	for c, b := range before {
+		if _, found := after[c]; !found {
+			total.Dropped.add(b.Size)
+			owner(b.Owner).Dropped.add(b.Size)
+		}
+	}
+	return total, sortOwnerChurn(owners)
+}
+
+// ChurnSummary totals the churn of a range of consecutive state roots.
+type ChurnSummary struct {
+	From   int64
+	To     int64
+	States int
+	BlockChurn
+	// Owners are ordered by new bytes, largest first.
+	Owners []OwnerChurn
+}
+
+// SummarizeChurn totals the churn of states, ordered by height.
+func SummarizeChurn(states []StateChurn) ChurnSummary {
+	var s ChurnSummary
+	if len(states) == 0 {
+		return s
+	}
+	s.From, s.To, s.States = states[0].Height, states[len(states)-1].Height, len(states)
+	owners := make(map[string]*BlockChurn)
+	for _, st := range states {
+		s.merge(st.BlockChurn)
+		for _, o := range st.Owners {
+			if owners[o.Owner] == nil {
+				owners[o.Owner] = &BlockChurn{}
+			}
+			owners[o.Owner].merge(o.BlockChurn)
+		}
+	}
+	s.Owners = sortOwnerChurn(owners)
+	return s
+}
+
+func sortOwnerChurn(owners map[string]*BlockChurn) []OwnerChurn {
+	var sorted []OwnerChurn
+	for name, c := range owners {
+		sorted = append(sorted, OwnerChurn{Owner: name, BlockChurn: *c})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].New.Bytes != sorted[j].New.Bytes {
+			return sorted[i].New.Bytes > sorted[j].New.Bytes
+		}
+		return sorted[i].Owner < sorted[j].Owner
+	})
+	return sorted
+}
@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	migration2 "github.com/filecoin-project/specs-actors/v2/actors/migration"
+	cid "github.com/ipfs/go-cid"
+)
+
+// testBlockCid returns the CID of a block holding v.
+func testBlockCid(t *testing.T, v int64) cid.Cid {
+	c, err := newMemCborStore().Put(context.Background(), testValue(v))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestDiffStateBlocks(t *testing.T) {
+	root, hamtNode, minerHead, sectors, newSectors, newRoot := testBlockCid(t, 1), testBlockCid(t, 2),
+		testBlockCid(t, 3), testBlockCid(t, 4), testBlockCid(t, 5), testBlockCid(t, 6)
+	before := StateBlocks{
+		root:      {Owner: treeOwner, Size: 10},
+		hamtNode:  {Owner: treeOwner, Size: 100},
+		minerHead: {Owner: "miner", Size: 50},
+		sectors:   {Owner: "miner", Size: 400},
+	}
+	after := StateBlocks{
+		newRoot:    {Owner: treeOwner, Size: 12},
+		hamtNode:   {Owner: treeOwner, Size: 100},
+		minerHead:  {Owner: "miner", Size: 50},
+		newSectors: {Owner: "miner", Size: 450},
+	}
+	total, owners := DiffStateBlocks(before, after)
+	expected := BlockChurn{
+		New:     SizeCount{Blocks: 2, Bytes: 462},
+		Shared:  SizeCount{Blocks: 2, Bytes: 150},
+		Dropped: SizeCount{Blocks: 2, Bytes: 410},
+	}
+	if total != expected {
+		t.Fatalf("expected churn %+v, got %+v", expected, total)
+	}
+	expectedOwners := []OwnerChurn{
+		{Owner: "miner", BlockChurn: BlockChurn{
+			New:     SizeCount{Blocks: 1, Bytes: 450},
+			Shared:  SizeCount{Blocks: 1, Bytes: 50},
+			Dropped: SizeCount{Blocks: 1, Bytes: 400},
+		}},
+		{Owner: treeOwner, BlockChurn: BlockChurn{
+			New:     SizeCount{Blocks: 1, Bytes: 12},
+			Shared:  SizeCount{Blocks: 1, Bytes: 100},
+			Dropped: SizeCount{Blocks: 1, Bytes: 10},
+		}},
+	}
+	if !reflect.DeepEqual(owners, expectedOwners) {
+		t.Fatalf("expected owner churn %+v, got %+v", expectedOwners, owners)
+	}
+
+	// Blocks dropped by an owner with no blocks after are still attributed
+	_, owners = DiffStateBlocks(StateBlocks{sectors: {Owner: "multisig", Size: 5}}, StateBlocks{})
+	if len(owners) != 1 || owners[0].Owner != "multisig" || owners[0].Dropped != (SizeCount{Blocks: 1, Bytes: 5}) {
+		t.Fatalf("unexpected owner churn %+v", owners)
+	}
+
+	summary := SummarizeChurn([]StateChurn{
+		{Height: 10, BlockChurn: total, Owners: expectedOwners},
+		{Height: 11, BlockChurn: total, Owners: expectedOwners[1:]},
+	})
+	if summary.From != 10 || summary.To != 11 || summary.States != 2 || summary.New != (SizeCount{Blocks: 4, Bytes: 924}) {
+		t.Fatalf("unexpected churn summary %+v", summary)
+	}
+	if len(summary.Owners) != 2 || summary.Owners[0].Owner != "miner" || summary.Owners[1].New != (SizeCount{Blocks: 2, Bytes: 24}) {
+		t.Fatalf("unexpected churn summary owners %+v", summary.Owners)
+	}
+}
+
+// TestLoadStateBlocksOwnersAcrossUpgrade checks the owners of the blocks of
+// a state and its migration, which churn reports attribute blocks to.
+func TestLoadStateBlocksOwnersAcrossUpgrade(t *testing.T) {
+	ctx := context.Background()
+	store := newMemCborStore()
+	tree, _ := testV0Tree(t, ctx, store)
+	rootV0, err := tree.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootV2, err := migration2.MigrateStateTree(ctx, store, rootV0, 10, migration2.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	load := func(root cid.Cid) StateBlocks {
+		st, err := LoadStateTree(ctx, store, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks, err := LoadStateBlocks(ctx, store, st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return blocks
+	}
+	_, owners := DiffStateBlocks(load(rootV0), load(rootV2))
+	var names []string
+	for _, o := range owners {
+		names = append(names, o.Owner)
+	}
+	sort.Strings(names)
+	expected := []string{"account", "cron", "init", "market", "power", "reward", treeOwner, "system", "verifreg"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected owners %v, got %v", expected, names)
+	}
+}
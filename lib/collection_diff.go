@@ -0,0 +1,309 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"sort"
+
+	amt "github.com/filecoin-project/go-amt-ipld/v2"
+	"github.com/filecoin-project/go-hamt-ipld/v2"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+)
+
+// DiffHAMT calls cb with the raw key and values of every key whose value
+// differs between the HAMTs at rootA and rootB, nil on the side missing the
+// key.  Subtrees linked by the same CID in both are skipped without being
+// loaded.  An undefined root is an empty HAMT.  Keys are visited in the
+// HAMT's hash order.  The HAMT encoding and hash are the same in actors v0
+// and v2, so this diffs the collections of either.
+func DiffHAMT(ctx context.Context, store cbornode.IpldStore, rootA, rootB cid.Cid, cb func(key string, a, b []byte) error) error {
+	if rootA.Equals(rootB) {
+		return nil
+	}
+	d := &hamtDiffer{ctx: ctx, store: store, cb: cb}
+	nodeA, err := d.load(rootA)
+	if err != nil {
+		return err
+	}
+	nodeB, err := d.load(rootB)
+	if err != nil {
+		return err
+	}
+	return d.diff(nodeA, nodeB)
+}
+
+type hamtDiffer struct {
+	ctx   context.Context
+	store cbornode.IpldStore
+	cb    func(key string, a, b []byte) error
+}
+
+func (d *hamtDiffer) load(root cid.Cid) (*hamt.Node, error) {
+	var node hamt.Node
+	if root.Defined() {
+		if err := d.store.Get(d.ctx, root, &node); err != nil {
+			return nil, err
+		}
+	}
+	return &node, nil
+}
+
+func (d *hamtDiffer) diff(a, b *hamt.Node) error {
+	slots := bitLen(a.Bitfield)
+	if n := bitLen(b.Bitfield); n > slots {
+		slots = n
+	}
+	for i := 0; i < slots; i++ {
+		pa, pb := hamtPointer(a, i), hamtPointer(b, i)
+		if pa == nil && pb == nil {
+			continue
+		}
+		if pa != nil && pb != nil && pa.Link.Defined() && pb.Link.Defined() {
+			if pa.Link.Equals(pb.Link) {
+				continue
+			}
+			childA, err := d.load(pa.Link)
+			if err != nil {
+				return err
+			}
+			childB, err := d.load(pb.Link)
+			if err != nil {
+				return err
+			}
+			if err := d.diff(childA, childB); err != nil {
+				return err
+			}
+			continue
+		}
+		// A bucket on either side, or a slot used on one side only: compare
+		// the entries of both by key.
+		kvsA, err := d.entries(pa)
+		if err != nil {
+			return err
+		}
+		kvsB, err := d.entries(pb)
+		if err != nil {
+			return err
+		}
+		if err := diffEntries(kvsA, kvsB, d.cb); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entries returns the entries held in or under p by key.
+func (d *hamtDiffer) entries(p *hamt.Pointer) (map[string][]byte, error) {
+	kvs := make(map[string][]byte)
+	var walk func(p *hamt.Pointer) error
+	walk = func(p *hamt.Pointer) error {
+		if p == nil {
+			return nil
+		}
+		if !p.Link.Defined() {
+			for _, kv := range p.KVs {
+				kvs[string(kv.Key)] = kv.Value.Raw
+			}
+			return nil
+		}
+		child, err := d.load(p.Link)
+		if err != nil {
+			return err
+		}
+		for _, cp := range child.Pointers {
+			if err := walk(cp); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return kvs, walk(p)
+}
+
+func diffEntries(kvsA, kvsB map[string][]byte, cb func(key string, a, b []byte) error) error {
+	keys := make([]string, 0, len(kvsA)+len(kvsB))
+	for k := range kvsA {
+		keys = append(keys, k)
+	}
+	for k := range kvsB {
+		if _, found := kvsA[k]; !found {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		a, b := kvsA[k], kvsB[k]
+		if a != nil && b != nil && bytes.Equal(a, b) {
+			continue
+		}
+		if err := cb(k, a, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func bitLen(bf *big.Int) int {
+	if bf == nil {
+		return 0
+	}
+	return bf.BitLen()
+}
+
+// hamtPointer returns the pointer of n at slot i, or nil if the slot is
+// unused.  Pointers are stored densely in slot order.
+func hamtPointer(n *hamt.Node, i int) *hamt.Pointer {
+	if n.Bitfield == nil || n.Bitfield.Bit(i) == 0 {
+		return nil
+	}
+	idx := 0
+	for j := 0; j < i; j++ {
+		idx += int(n.Bitfield.Bit(j))
+	}
+	return n.Pointers[idx]
+}
+
+// amtWidth is the number of children of an AMT node in actors v0 and v2.
+const amtWidth = 8
+
+// DiffAMT calls cb with the index and raw values of every index whose value
+// differs between the AMTs at rootA and rootB, nil on the side missing the
+// index.  Subtrees linked by the same CID in both are skipped without being
+// loaded.  An undefined root is an empty AMT.  Indexes are visited in
+// ascending order.  The AMT encoding is the same in actors v0 and v2.
+func DiffAMT(ctx context.Context, store cbornode.IpldStore, rootA, rootB cid.Cid, cb func(i uint64, a, b []byte) error) error {
+	if rootA.Equals(rootB) {
+		return nil
+	}
+	d := &amtDiffer{ctx: ctx, store: store, cb: cb}
+	var a, b amt.Root
+	if rootA.Defined() {
+		if err := store.Get(ctx, rootA, &a); err != nil {
+			return err
+		}
+	}
+	if rootB.Defined() {
+		if err := store.Get(ctx, rootB, &b); err != nil {
+			return err
+		}
+	}
+	return d.diff(&a.Node, a.Height, &b.Node, b.Height, 0)
+}
+
+type amtDiffer struct {
+	ctx   context.Context
+	store cbornode.IpldStore
+	cb    func(i uint64, a, b []byte) error
+}
+
+// diff compares node a of height ha with node b of height hb, both covering
+// the indexes from offset.  The shorter node covers the first child of the
+// taller, whose other children exist on its side only.
+func (d *amtDiffer) diff(a *amt.Node, ha uint64, b *amt.Node, hb uint64, offset uint64) error {
+	if ha != hb {
+		tall, short, swap := a, b, false
+		h, hs := ha, hb
+		if hb > ha {
+			tall, short, swap, h, hs = b, a, true, hb, ha
+		}
+		for i := uint64(0); i < amtWidth; i++ {
+			child, err := d.child(tall, i)
+			if err != nil {
+				return err
+			}
+			other, ho := &amt.Node{}, h-1
+			if i == 0 {
+				other, ho = short, hs
+			} else if !amtIsSet(tall, i) {
+				continue
+			}
+			childOffset := offset + i*amtNodesForHeight(h)
+			if swap {
+				err = d.diff(other, ho, child, h-1, childOffset)
+			} else {
+				err = d.diff(child, h-1, other, ho, childOffset)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for i := uint64(0); i < amtWidth; i++ {
+		setA, setB := amtIsSet(a, i), amtIsSet(b, i)
+		if !setA && !setB {
+			continue
+		}
+		if ha == 0 {
+			var va, vb []byte
+			if setA {
+				va = a.Values[amtIndex(a, i)].Raw
+			}
+			if setB {
+				vb = b.Values[amtIndex(b, i)].Raw
+			}
+			if setA && setB && bytes.Equal(va, vb) {
+				continue
+			}
+			if err := d.cb(offset+i, va, vb); err != nil {
+				return err
+			}
+			continue
+		}
+		if setA && setB && a.Links[amtIndex(a, i)].Equals(b.Links[amtIndex(b, i)]) {
+			continue
+		}
+		childA, err := d.child(a, i)
+		if err != nil {
+			return err
+		}
+		childB, err := d.child(b, i)
+		if err != nil {
+			return err
+		}
+		if err := d.diff(childA, ha-1, childB, hb-1, offset+i*amtNodesForHeight(ha)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// child loads the child of n at slot i, or returns an empty node if the slot
+// is unused.
+func (d *amtDiffer) child(n *amt.Node, i uint64) (*amt.Node, error) {
+	var child amt.Node
+	if amtIsSet(n, i) {
+		if err := d.store.Get(d.ctx, n.Links[amtIndex(n, i)], &child); err != nil {
+			return nil, err
+		}
+	}
+	return &child, nil
+}
+
+func amtIsSet(n *amt.Node, i uint64) bool {
+	return n.Bmap[0]&(1<<i) != 0
+}
+
+// amtIndex returns the position of slot i among the links or values of n.
+func amtIndex(n *amt.Node, i uint64) int {
+	idx := 0
+	for j := uint64(0); j < i; j++ {
+		if amtIsSet(n, j) {
+			idx++
+		}
+	}
+	return idx
+}
+
+// amtNodesForHeight returns the number of indexes covered by each child of
+// a node of height h.
+func amtNodesForHeight(h uint64) uint64 {
+	n := uint64(1)
+	for ; h > 0; h-- {
+		n *= amtWidth
+	}
+	return n
+}
@@ -0,0 +1,240 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	adt2 "github.com/filecoin-project/specs-actors/v2/actors/util/adt"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// countingStore counts the blocks read through it.
+type countingStore struct {
+	cbornode.IpldStore
+	gets int
+}
+
+func (s *countingStore) Get(ctx context.Context, c cid.Cid, out interface{}) error {
+	s.gets++
+	return s.IpldStore.Get(ctx, c, out)
+}
+
+func testValue(v int64) *cbg.CborInt {
+	i := cbg.CborInt(v)
+	return &i
+}
+
+// testHAMT builds a HAMT mapping each key of values to its value.
+func testHAMT(t *testing.T, store adt2.Store, values map[uint64]int64) cid.Cid {
+	m := adt2.MakeEmptyMap(store)
+	for k, v := range values {
+		if err := m.Put(abi.UIntKey(k), testValue(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root, err := m.Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+// testAMT builds an AMT setting each index of values to its value.
+func testAMT(t *testing.T, store adt2.Store, values map[uint64]int64) cid.Cid {
+	a := adt2.MakeEmptyArray(store)
+	for i, v := range values {
+		if err := a.Set(i, testValue(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root, err := a.Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func copyValues(values map[uint64]int64) map[uint64]int64 {
+	c := make(map[uint64]int64, len(values))
+	for k, v := range values {
+		c[k] = v
+	}
+	return c
+}
+
+// change is a difference reported by DiffHAMT or DiffAMT, with a value of -1
+// on the side missing the key.
+type change struct {
+	a, b int64
+}
+
+func decodeTestValue(t *testing.T, raw []byte) int64 {
+	if raw == nil {
+		return -1
+	}
+	var v cbg.CborInt
+	if err := v.UnmarshalCBOR(bytes.NewReader(raw)); err != nil {
+		t.Fatal(err)
+	}
+	return int64(v)
+}
+
+func diffTestHAMTs(t *testing.T, ctx context.Context, store cbornode.IpldStore, rootA, rootB cid.Cid) map[uint64]change {
+	changes := make(map[uint64]change)
+	if err := DiffHAMT(ctx, store, rootA, rootB, func(key string, a, b []byte) error {
+		k, err := abi.ParseUIntKey(key)
+		if err != nil {
+			return err
+		}
+		changes[k] = change{decodeTestValue(t, a), decodeTestValue(t, b)}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return changes
+}
+
+func diffTestAMTs(t *testing.T, ctx context.Context, store cbornode.IpldStore, rootA, rootB cid.Cid) map[uint64]change {
+	changes := make(map[uint64]change)
+	if err := DiffAMT(ctx, store, rootA, rootB, func(i uint64, a, b []byte) error {
+		changes[i] = change{decodeTestValue(t, a), decodeTestValue(t, b)}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return changes
+}
+
+func TestDiffHAMT(t *testing.T) {
+	ctx := context.Background()
+	store := newMemCborStore()
+	adtStore := adt2.WrapStore(ctx, store)
+	before := make(map[uint64]int64)
+	for k := uint64(0); k < 500; k++ {
+		before[k] = int64(k)
+	}
+	after := copyValues(before)
+	delete(after, 7)
+	after[42] = 4200
+	after[1000] = 1
+	rootA, rootB := testHAMT(t, adtStore, before), testHAMT(t, adtStore, after)
+
+	expected := map[uint64]change{7: {7, -1}, 42: {42, 4200}, 1000: {-1, 1}}
+	if changes := diffTestHAMTs(t, ctx, store, rootA, rootB); !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("expected changes %v, got %v", expected, changes)
+	}
+	reversed := map[uint64]change{7: {-1, 7}, 42: {4200, 42}, 1000: {1, -1}}
+	if changes := diffTestHAMTs(t, ctx, store, rootB, rootA); !reflect.DeepEqual(changes, reversed) {
+		t.Fatalf("expected changes %v, got %v", reversed, changes)
+	}
+	// An undefined root is an empty HAMT
+	if changes := diffTestHAMTs(t, ctx, store, cid.Undef, rootA); len(changes) != len(before) {
+		t.Fatalf("expected %d added keys, got %d", len(before), len(changes))
+	}
+	if changes := diffTestHAMTs(t, ctx, store, rootA, rootA); len(changes) != 0 {
+		t.Fatalf("expected no changes between equal roots, got %v", changes)
+	}
+}
+
+func TestDiffHAMTSkipsSharedSubtrees(t *testing.T) {
+	ctx := context.Background()
+	store := &countingStore{IpldStore: newMemCborStore()}
+	adtStore := adt2.WrapStore(ctx, store)
+	before := make(map[uint64]int64)
+	for k := uint64(0); k < 5000; k++ {
+		before[k] = int64(k)
+	}
+	after := copyValues(before)
+	after[1234] = -5
+	rootA, rootB := testHAMT(t, adtStore, before), testHAMT(t, adtStore, after)
+
+	store.gets = 0
+	diffTestHAMTs(t, ctx, store, cid.Undef, rootA)
+	allNodes := store.gets
+	store.gets = 0
+	if changes := diffTestHAMTs(t, ctx, store, rootA, rootB); len(changes) != 1 {
+		t.Fatalf("expected one changed key, got %v", changes)
+	}
+	// Only the nodes on the path to the changed key are loaded, on each side
+	if store.gets >= allNodes/4 {
+		t.Fatalf("diff of one key loaded %d blocks, the HAMT has %d nodes", store.gets, allNodes)
+	}
+}
+
+func TestDiffAMT(t *testing.T) {
+	ctx := context.Background()
+	store := newMemCborStore()
+	adtStore := adt2.WrapStore(ctx, store)
+	before := make(map[uint64]int64)
+	for i := uint64(0); i < 100; i++ {
+		before[i] = int64(i)
+	}
+	after := copyValues(before)
+	delete(after, 3)
+	after[50] = 5000
+	after[150] = 1
+	rootA, rootB := testAMT(t, adtStore, before), testAMT(t, adtStore, after)
+
+	expected := map[uint64]change{3: {3, -1}, 50: {50, 5000}, 150: {-1, 1}}
+	if changes := diffTestAMTs(t, ctx, store, rootA, rootB); !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("expected changes %v, got %v", expected, changes)
+	}
+	if changes := diffTestAMTs(t, ctx, store, cid.Undef, rootA); len(changes) != len(before) {
+		t.Fatalf("expected %d added indexes, got %d", len(before), len(changes))
+	}
+}
+
+func TestDiffAMTHeights(t *testing.T) {
+	ctx := context.Background()
+	store := newMemCborStore()
+	adtStore := adt2.WrapStore(ctx, store)
+	// A height 0 AMT against AMTs of heights 1 and 3 holding its indexes
+	short := map[uint64]int64{0: 0, 2: 2, 5: 5}
+	taller := copyValues(short)
+	taller[2] = 20
+	taller[9] = 9
+	tallest := copyValues(short)
+	delete(tallest, 5)
+	tallest[4000] = 4
+
+	rootShort, rootTaller, rootTallest := testAMT(t, adtStore, short), testAMT(t, adtStore, taller), testAMT(t, adtStore, tallest)
+	expected := map[uint64]change{2: {2, 20}, 9: {-1, 9}}
+	if changes := diffTestAMTs(t, ctx, store, rootShort, rootTaller); !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("expected changes %v, got %v", expected, changes)
+	}
+	expected = map[uint64]change{5: {5, -1}, 4000: {-1, 4}}
+	if changes := diffTestAMTs(t, ctx, store, rootShort, rootTallest); !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("expected changes %v, got %v", expected, changes)
+	}
+	expected = map[uint64]change{5: {-1, 5}, 4000: {4, -1}}
+	if changes := diffTestAMTs(t, ctx, store, rootTallest, rootShort); !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("expected changes %v, got %v", expected, changes)
+	}
+}
+
+func TestDiffAMTSkipsSharedSubtrees(t *testing.T) {
+	ctx := context.Background()
+	store := &countingStore{IpldStore: newMemCborStore()}
+	adtStore := adt2.WrapStore(ctx, store)
+	before := make(map[uint64]int64)
+	for i := uint64(0); i < 4096; i++ {
+		before[i] = int64(i)
+	}
+	after := copyValues(before)
+	after[2000] = -1
+	rootA, rootB := testAMT(t, adtStore, before), testAMT(t, adtStore, after)
+
+	store.gets = 0
+	if changes := diffTestAMTs(t, ctx, store, rootA, rootB); len(changes) != 1 {
+		t.Fatalf("expected one changed index, got %v", changes)
+	}
+	// Two roots and the three nodes below each on the path to index 2000
+	if store.gets != 8 {
+		t.Fatalf("expected the diff of one index to load 8 blocks, got %d", store.gets)
+	}
+}
@@ -0,0 +1,413 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"math/bits"
+	"reflect"
+	"sort"
+
+	address "github.com/filecoin-project/go-address"
+	amt "github.com/filecoin-project/go-amt-ipld/v2"
+	"github.com/filecoin-project/go-hamt-ipld/v2"
+	states2 "github.com/filecoin-project/specs-actors/v2/actors/states"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+)
+
+// actorsHAMTBitwidth is the bitwidth of every HAMT of actors v0 and v2.
+const actorsHAMTBitwidth = 5
+
+// SizeHistogram counts sizes in bytes in power of two buckets.
+type SizeHistogram struct {
+	Count uint64
+	Total uint64
+	Max   uint64
+	// Buckets[i] counts the sizes of i bits, see BucketRange.
+	Buckets []uint64
+}
+
+// Add counts one size.
+func (h *SizeHistogram) Add(size int) {
+	b := bits.Len(uint(size))
+	for len(h.Buckets) <= b {
+		h.Buckets = append(h.Buckets, 0)
+	}
+	h.Buckets[b]++
+	h.Count++
+	h.Total += uint64(size)
+	if uint64(size) > h.Max {
+		h.Max = uint64(size)
+	}
+}
+
+// Merge adds the counts of o to h.
+func (h *SizeHistogram) Merge(o SizeHistogram) {
+	for len(h.Buckets) < len(o.Buckets) {
+		h.Buckets = append(h.Buckets, 0)
+	}
+	for i, n := range o.Buckets {
+		h.Buckets[i] += n
+	}
+	h.Count += o.Count
+	h.Total += o.Total
+	if o.Max > h.Max {
+		h.Max = o.Max
+	}
+}
+
+// Mean returns the mean size, zero if nothing was counted.
+func (h *SizeHistogram) Mean() float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	return float64(h.Total) / float64(h.Count)
+}
+
+// BucketRange returns the smallest and largest size counted in bucket i.
+func BucketRange(i int) (uint64, uint64) {
+	if i == 0 {
+		return 0, 0
+	}
+	return 1 << uint(i-1), 1<<uint(i) - 1
+}
+
+// CollectionStats describes the structure of a HAMT or AMT, or of all the
+// HAMTs or AMTs found under the same name in a state tree.
+type CollectionStats struct {
+	// Name is the actor type and field path of the collection, e.g.
+	// miner.Deadlines.Due.Partitions.  Collections held in entries of a
+	// collection, such as the AMTs of a multimap, have [] appended.
+	Name string
+	// Kind is HAMT or AMT
+	Kind string
+	// Instances counts the collections measured, of which the largest by
+	// entries is held by actor Largest.
+	Instances      int
+	Largest        address.Address
+	LargestEntries uint64
+	Entries        uint64
+	Nodes          uint64
+	// Depth is the most levels of nodes of any instance, one for a
+	// collection that is a root node only.
+	Depth int
+	// Slots counts the pointer slots of all nodes, UsedSlots the slots in
+	// use.
+	Slots     uint64
+	UsedSlots uint64
+	// Bytes is the serialized size of all nodes.
+	Bytes uint64
+	// PathBlocks and PathBytes sum, over all entries, the nodes from the
+	// root to the node holding the entry and their bytes: the blocks a read
+	// of the entry loads and an update of it writes.
+	PathBlocks uint64
+	PathBytes  uint64
+	Keys       SizeHistogram
+	Values     SizeHistogram
+}
+
+// FanoutUse returns the share of node slots in use.
+func (s *CollectionStats) FanoutUse() float64 {
+	if s.Slots == 0 {
+		return 0
+	}
+	return float64(s.UsedSlots) / float64(s.Slots)
+}
+
+// MeanPathBlocks returns the mean number of blocks read to get an entry,
+// which is also the number written to update one.
+func (s *CollectionStats) MeanPathBlocks() float64 {
+	if s.Entries == 0 {
+		return 0
+	}
+	return float64(s.PathBlocks) / float64(s.Entries)
+}
+
+// MeanPathBytes returns the mean bytes of the blocks read to get an entry.
+func (s *CollectionStats) MeanPathBytes() float64 {
+	if s.Entries == 0 {
+		return 0
+	}
+	return float64(s.PathBytes) / float64(s.Entries)
+}
+
+// merge adds the measurements of the collection o held by actor owner.
+func (s *CollectionStats) merge(o *CollectionStats, owner address.Address) {
+	s.Instances += o.Instances
+	if s.Largest == address.Undef || o.Entries > s.LargestEntries {
+		s.Largest, s.LargestEntries = owner, o.Entries
+	}
+	s.Entries += o.Entries
+	s.Nodes += o.Nodes
+	if o.Depth > s.Depth {
+		s.Depth = o.Depth
+	}
+	s.Slots += o.Slots
+	s.UsedSlots += o.UsedSlots
+	s.Bytes += o.Bytes
+	s.PathBlocks += o.PathBlocks
+	s.PathBytes += o.PathBytes
+	s.Keys.Merge(o.Keys)
+	s.Values.Merge(o.Values)
+}
+
+func loadRaw(ctx context.Context, store cbornode.IpldStore, c cid.Cid) ([]byte, error) {
+	var d cbg.Deferred
+	if err := store.Get(ctx, c, &d); err != nil {
+		return nil, err
+	}
+	return d.Raw, nil
+}
+
+// MeasureHAMT measures the HAMT at root whose nodes have 2^bitwidth slots,
+// calling cb, if not nil, with every entry.
+func MeasureHAMT(ctx context.Context, store cbornode.IpldStore, root cid.Cid, bitwidth int, cb func(k string, raw []byte) error) (*CollectionStats, error) {
+	s := &CollectionStats{Kind: "HAMT", Instances: 1}
+	var walk func(c cid.Cid, depth int, pathBytes uint64) error
+	walk = func(c cid.Cid, depth int, pathBytes uint64) error {
+		raw, err := loadRaw(ctx, store, c)
+		if err != nil {
+			return err
+		}
+		pathBytes += uint64(len(raw))
+		var node hamt.Node
+		if err := node.UnmarshalCBOR(bytes.NewReader(raw)); err != nil {
+			return err
+		}
+		s.Nodes++
+		s.Bytes += uint64(len(raw))
+		s.Slots += 1 << uint(bitwidth)
+		s.UsedSlots += uint64(len(node.Pointers))
+		if depth > s.Depth {
+			s.Depth = depth
+		}
+		for _, p := range node.Pointers {
+			if p.Link.Defined() {
+				if err := walk(p.Link, depth+1, pathBytes); err != nil {
+					return err
+				}
+				continue
+			}
+			for _, kv := range p.KVs {
+				s.Entries++
+				s.PathBlocks += uint64(depth)
+				s.PathBytes += pathBytes
+				s.Keys.Add(len(kv.Key))
+				s.Values.Add(len(kv.Value.Raw))
+				if cb != nil {
+					if err := cb(string(kv.Key), kv.Value.Raw); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(root, 1, 0); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// MeasureAMT measures the AMT at root, calling cb, if not nil, with every
+// entry.  AMT keys are implied by position and not counted.
+func MeasureAMT(ctx context.Context, store cbornode.IpldStore, root cid.Cid, cb func(i uint64, raw []byte) error) (*CollectionStats, error) {
+	s := &CollectionStats{Kind: "AMT", Instances: 1}
+	raw, err := loadRaw(ctx, store, root)
+	if err != nil {
+		return nil, err
+	}
+	var r amt.Root
+	if err := r.UnmarshalCBOR(bytes.NewReader(raw)); err != nil {
+		return nil, err
+	}
+	s.Depth = int(r.Height) + 1
+	var walk func(n *amt.Node, size int, height, offset, pathBytes uint64) error
+	walk = func(n *amt.Node, size int, height, offset, pathBytes uint64) error {
+		s.Nodes++
+		s.Bytes += uint64(size)
+		pathBytes += uint64(size)
+		s.Slots += amtWidth
+		s.UsedSlots += uint64(bits.OnesCount8(n.Bmap[0]))
+		for i := uint64(0); i < amtWidth; i++ {
+			if !amtIsSet(n, i) {
+				continue
+			}
+			idx := amtIndex(n, i)
+			if height == 0 {
+				v := n.Values[idx].Raw
+				s.Entries++
+				s.PathBlocks += uint64(s.Depth)
+				s.PathBytes += pathBytes
+				s.Values.Add(len(v))
+				if cb != nil {
+					if err := cb(offset+i, v); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			childRaw, err := loadRaw(ctx, store, n.Links[idx])
+			if err != nil {
+				return err
+			}
+			var child amt.Node
+			if err := child.UnmarshalCBOR(bytes.NewReader(childRaw)); err != nil {
+				return err
+			}
+			if err := walk(&child, len(childRaw), height-1, offset+i*amtNodesForHeight(height), pathBytes); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(&r.Node, len(raw), r.Height, 0, 0); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// MeasureCollections measures the actors HAMT at actorsRoot and every HAMT
+// and AMT linked from the actor states in it, of any actors version,
+// following the same links as ExpandActorState.  Collections are merged by
+// name, so all miners' sectors are measured as one miner.Sectors entry.  The
+// result is ordered by bytes, largest first.
+func MeasureCollections(ctx context.Context, store cbornode.IpldStore, actorsRoot cid.Cid) ([]*CollectionStats, error) {
+	w := &collectionWalker{ctx: ctx, store: store, stats: make(map[string]*CollectionStats)}
+	tree, err := MeasureHAMT(ctx, store, actorsRoot, actorsHAMTBitwidth, func(k string, raw []byte) error {
+		addr, err := address.NewFromBytes([]byte(k))
+		if err != nil {
+			return err
+		}
+		var a states2.Actor
+		if err := a.UnmarshalCBOR(bytes.NewReader(raw)); err != nil {
+			return err
+		}
+		newState, found := actorStates[a.Code]
+		if !found {
+			return xerrors.Errorf("unknown actor code %s of %s", a.Code, addr)
+		}
+		st := newState()
+		if _, hasLinks := stateLinks[reflect.TypeOf(st)]; !hasLinks {
+			return nil
+		}
+		if err := w.store.Get(ctx, a.Head, st); err != nil {
+			return xerrors.Errorf("failed to load state of %s: %w", addr, err)
+		}
+		return w.walkObject(ActorTypeName(a.Code), addr, st)
+	})
+	if err != nil {
+		return nil, err
+	}
+	w.add("actors", address.Undef, tree)
+
+	out := make([]*CollectionStats, 0, len(w.stats))
+	for _, s := range w.stats {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Bytes != out[j].Bytes {
+			return out[i].Bytes > out[j].Bytes
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out, nil
+}
+
+// collectionWalker measures the collections linked from state objects.
+type collectionWalker struct {
+	ctx   context.Context
+	store cbornode.IpldStore
+	stats map[string]*CollectionStats
+}
+
+func (w *collectionWalker) add(name string, owner address.Address, s *CollectionStats) {
+	total, found := w.stats[name]
+	if !found {
+		total = &CollectionStats{Name: name, Kind: s.Kind}
+		w.stats[name] = total
+	}
+	total.merge(s, owner)
+}
+
+// walkObject measures the collections linked from the fields of obj.
+func (w *collectionWalker) walkObject(name string, owner address.Address, obj interface{}) error {
+	links, found := stateLinks[reflect.TypeOf(obj)]
+	if !found {
+		return nil
+	}
+	v := reflect.ValueOf(obj).Elem()
+	for field, link := range links {
+		path := name + "." + field
+		f := v.FieldByName(field)
+		if root, ok := linkRoot(f); ok {
+			if err := w.walkLink(path, owner, link, root); err != nil {
+				return err
+			}
+			continue
+		}
+		// Arrays of links such as miner Deadlines.Due
+		for i := 0; i < f.Len(); i++ {
+			if err := w.walkLink(path, owner, link, f.Index(i).Interface().(cid.Cid)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *collectionWalker) walkLink(name string, owner address.Address, link stateLink, root cid.Cid) error {
+	if !root.Defined() {
+		return nil
+	}
+	var s *CollectionStats
+	var err error
+	switch link.kind {
+	case linkObject:
+		obj := link.value()
+		if err := w.store.Get(w.ctx, root, obj); err != nil {
+			return xerrors.Errorf("failed to load %s of %s: %w", name, owner, err)
+		}
+		return w.walkObject(name, owner, obj)
+	case linkAMT:
+		s, err = MeasureAMT(w.ctx, w.store, root, func(_ uint64, raw []byte) error {
+			return w.walkElement(name, owner, link, raw)
+		})
+	case linkHAMT, linkSet:
+		s, err = MeasureHAMT(w.ctx, w.store, root, actorsHAMTBitwidth, func(_ string, raw []byte) error {
+			if link.kind == linkSet {
+				return nil
+			}
+			return w.walkElement(name, owner, link, raw)
+		})
+	default:
+		err = xerrors.Errorf("unknown link kind %d", link.kind)
+	}
+	if err != nil {
+		return xerrors.Errorf("failed to measure %s of %s: %w", name, owner, err)
+	}
+	w.add(name, owner, s)
+	return nil
+}
+
+// walkElement measures the collections held in or linked from a collection
+// entry.
+func (w *collectionWalker) walkElement(name string, owner address.Address, link stateLink, raw []byte) error {
+	if link.nested != nil {
+		root, err := decodeRoot(raw)
+		if err != nil {
+			return err
+		}
+		return w.walkLink(name+"[]", owner, *link.nested, root)
+	}
+	elem := link.value()
+	if _, found := stateLinks[reflect.TypeOf(elem)]; !found {
+		return nil
+	}
+	if err := elem.UnmarshalCBOR(bytes.NewReader(raw)); err != nil {
+		return err
+	}
+	return w.walkObject(name, owner, elem)
+}
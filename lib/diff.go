@@ -0,0 +1,395 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+
+	address "github.com/filecoin-project/go-address"
+	states2 "github.com/filecoin-project/specs-actors/v2/actors/states"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+)
+
+// ActorDiff describes how a single actor differs between two state trees.
+// Before is nil for added actors and After is nil for removed actors.
+type ActorDiff struct {
+	Address address.Address
+	Before  *states2.Actor
+	After   *states2.Actor
+}
+
+// DiffStateTrees returns every actor whose code, head, nonce or balance
+// differs between treeA and treeB, ordered by address.  Parts of the trees
+// shared by both are skipped with DiffHAMT.
+func DiffStateTrees(treeA, treeB *states2.Tree) ([]ActorDiff, error) {
+	rootA, err := treeA.Map.Root()
+	if err != nil {
+		return nil, err
+	}
+	rootB, err := treeB.Map.Root()
+	if err != nil {
+		return nil, err
+	}
+	ctx := treeA.Store.Context()
+	var diffs []ActorDiff
+	if err := DiffHAMT(ctx, treeA.Store, rootA, rootB, func(key string, a, b []byte) error {
+		addr, err := address.NewFromBytes([]byte(key))
+		if err != nil {
+			return err
+		}
+		d := ActorDiff{Address: addr}
+		if d.Before, err = decodeActor(a); err != nil {
+			return err
+		}
+		if d.After, err = decodeActor(b); err != nil {
+			return err
+		}
+		if d.Before == nil || d.After == nil || !actorsEqual(d.Before, d.After) {
+			diffs = append(diffs, d)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Address.String() < diffs[j].Address.String()
+	})
+	return diffs, nil
+}
+
+func decodeActor(raw []byte) (*states2.Actor, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	var a states2.Actor
+	if err := a.UnmarshalCBOR(bytes.NewReader(raw)); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func actorsEqual(a, b *states2.Actor) bool {
+	return a.Code.Equals(b.Code) &&
+		a.Head.Equals(b.Head) &&
+		a.CallSeqNum == b.CallSeqNum &&
+		a.Balance.Equals(b.Balance)
+}
+
+// ActorStateDiff is an actor differing between two state trees along with
+// the fields of it that differ.
+type ActorStateDiff struct {
+	ActorDiff
+	Fields []FieldDiff
+}
+
+// FieldDiff is a difference in one field of two decoded actor states.  Path
+// names the field from the state, e.g. Info.Worker, and the key or index of
+// collection entries, e.g. Sectors[12].Expiration.  Before is nil for added
+// collection entries and After is nil for removed ones.
+type FieldDiff struct {
+	Path   string
+	Before interface{}
+	After  interface{}
+}
+
+// DiffActorStates returns the field level differences between the decoded
+// states of actors a and b.  The HAMTs, AMTs and linked objects of the
+// states are followed as in ExpandActorState and diffed by key with DiffHAMT
+// and DiffAMT, skipping links equal in both.  States of different types are
+// reported as a single difference with an empty path.
+func DiffActorStates(ctx context.Context, store cbornode.IpldStore, a, b *states2.Actor) ([]FieldDiff, error) {
+	d := &stateDiffer{ctx: ctx, store: store}
+	return d.diffStates(a, b)
+}
+
+// stateDiffer collects the differences of decoded state objects.
+type stateDiffer struct {
+	ctx   context.Context
+	store cbornode.IpldStore
+	// migration matches the fields of v0 objects with those of the v2
+	// objects they migrate to and skips the fields the migration adds or
+	// recomputes, see DiffMigration.
+	migration bool
+	diffs     []FieldDiff
+}
+
+func (d *stateDiffer) add(path string, a, b interface{}) {
+	d.diffs = append(d.diffs, FieldDiff{Path: path, Before: a, After: b})
+}
+
+func (d *stateDiffer) diffStates(a, b *states2.Actor) ([]FieldDiff, error) {
+	if a.Head.Equals(b.Head) {
+		return nil, nil
+	}
+	stA, err := LoadActorState(d.ctx, d.store, a)
+	if err != nil {
+		return nil, err
+	}
+	stB, err := LoadActorState(d.ctx, d.store, b)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.diffValue("", reflect.ValueOf(stA), reflect.ValueOf(stB)); err != nil {
+		return nil, err
+	}
+	return d.diffs, nil
+}
+
+// diffValue diffs the fields of state objects and records field by field
+// and compares any other values as a whole.  Objects of different types are
+// only diffed field by field when diffing a migration.
+func (d *stateDiffer) diffValue(path string, a, b reflect.Value) error {
+	var nilA, nilB bool
+	for a.Kind() == reflect.Ptr && !nilA {
+		if nilA = a.IsNil(); !nilA {
+			a = a.Elem()
+		}
+	}
+	for b.Kind() == reflect.Ptr && !nilB {
+		if nilB = b.IsNil(); !nilB {
+			b = b.Elem()
+		}
+	}
+	if nilA || nilB {
+		if nilA != nilB {
+			d.add(path, a.Interface(), b.Interface())
+		}
+		return nil
+	}
+	linksA, foundA := stateLinks[reflect.PtrTo(a.Type())]
+	linksB, foundB := stateLinks[reflect.PtrTo(b.Type())]
+	objects := (foundA || isRecord(a.Type())) && (foundB || isRecord(b.Type()))
+	if !objects || (a.Type() != b.Type() && !d.migration) {
+		if !valuesEqual(a, b) {
+			d.add(path, a.Interface(), b.Interface())
+		}
+		return nil
+	}
+	for i := 0; i < b.NumField(); i++ {
+		name := b.Type().Field(i).Name
+		nameA := name
+		if d.migration {
+			if migrationRecomputed[b.Type()][name] {
+				continue
+			}
+			if renamed, ok := migrationRenames[b.Type()][name]; ok {
+				nameA = renamed
+			}
+		}
+		fieldA := a.FieldByName(nameA)
+		if !fieldA.IsValid() {
+			// A field added by the migration
+			continue
+		}
+		fieldPath := name
+		if path != "" {
+			fieldPath = path + "." + name
+		}
+		linkB, isLink := linksB[name]
+		var err error
+		if isLink {
+			err = d.diffLinkField(fieldPath, linksA[nameA], linkB, fieldA, b.Field(i))
+		} else {
+			err = d.diffValue(fieldPath, fieldA, b.Field(i))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isRecord reports whether values of t are plain structs of exported fields
+// worth diffing field by field, such as sector infos, rather than values
+// with an encoding of their own such as addresses and token amounts.
+func isRecord(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct || t.NumField() == 0 {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.PkgPath != "" || f.Anonymous {
+			return false
+		}
+	}
+	return true
+}
+
+// valuesEqual compares values deeply, falling back to their JSON encoding
+// for values such as token amounts whose representation is not canonical.
+func valuesEqual(a, b reflect.Value) bool {
+	if reflect.DeepEqual(a.Interface(), b.Interface()) {
+		return true
+	}
+	jsonA, err := json.Marshal(a.Interface())
+	if err != nil {
+		return false
+	}
+	jsonB, err := json.Marshal(b.Interface())
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(jsonA, jsonB)
+}
+
+// linkRoot returns the root held by a link or nullable link field, which is
+// undefined for a null link.
+func linkRoot(v reflect.Value) (cid.Cid, bool) {
+	switch root := v.Interface().(type) {
+	case cid.Cid:
+		return root, true
+	case *cid.Cid:
+		if root == nil {
+			return cid.Undef, true
+		}
+		return *root, true
+	}
+	return cid.Undef, false
+}
+
+// diffLinkField diffs a field holding a link, a nullable link or an array of
+// links such as miner Deadlines.Due.
+func (d *stateDiffer) diffLinkField(path string, linkA, linkB stateLink, a, b reflect.Value) error {
+	rootA, okA := linkRoot(a)
+	rootB, okB := linkRoot(b)
+	if okA && okB {
+		return d.diffLink(path, linkA, linkB, rootA, rootB)
+	}
+	cidType := reflect.TypeOf(cid.Cid{})
+	if a.Kind() != reflect.Array || a.Type().Elem() != cidType || b.Kind() != reflect.Array || b.Type().Elem() != cidType || a.Len() != b.Len() {
+		return xerrors.Errorf("field %s is not a link", path)
+	}
+	for i := 0; i < a.Len(); i++ {
+		elemPath := path + "[" + strconv.Itoa(i) + "]"
+		if err := d.diffLink(elemPath, linkA, linkB, a.Index(i).Interface().(cid.Cid), b.Index(i).Interface().(cid.Cid)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffLink diffs the objects or collections at rootA and rootB.  An
+// undefined root is a missing object or an empty collection.
+func (d *stateDiffer) diffLink(path string, linkA, linkB stateLink, rootA, rootB cid.Cid) error {
+	if rootA.Equals(rootB) {
+		return nil
+	}
+	if linkA.kind != linkB.kind {
+		return xerrors.Errorf("field %s links to different kinds of collections", path)
+	}
+	var err error
+	switch linkB.kind {
+	case linkObject:
+		var objA, objB cbg.CBORUnmarshaler
+		if objA, err = d.loadObject(linkA, rootA); err != nil {
+			return err
+		}
+		if objB, err = d.loadObject(linkB, rootB); err != nil {
+			return err
+		}
+		if objA == nil || objB == nil {
+			d.add(path, objA, objB)
+			return nil
+		}
+		err = d.diffValue(path, reflect.ValueOf(objA), reflect.ValueOf(objB))
+	case linkAMT:
+		err = DiffAMT(d.ctx, d.store, rootA, rootB, func(i uint64, a, b []byte) error {
+			return d.diffElement(path+"["+strconv.FormatUint(i, 10)+"]", linkA, linkB, a, b)
+		})
+	case linkHAMT, linkSet:
+		err = DiffHAMT(d.ctx, d.store, rootA, rootB, func(k string, a, b []byte) error {
+			key, err := parseKey(linkB.key, k)
+			if err != nil {
+				return err
+			}
+			elemPath := path + "[" + key + "]"
+			if linkB.kind == linkSet {
+				d.add(elemPath, setMember(a), setMember(b))
+				return nil
+			}
+			return d.diffElement(elemPath, linkA, linkB, a, b)
+		})
+	default:
+		err = xerrors.Errorf("unknown link kind %d", linkB.kind)
+	}
+	if err != nil {
+		return xerrors.Errorf("failed to diff %s: %w", path, err)
+	}
+	return nil
+}
+
+func (d *stateDiffer) loadObject(link stateLink, root cid.Cid) (cbg.CBORUnmarshaler, error) {
+	if !root.Defined() {
+		return nil, nil
+	}
+	obj := link.value()
+	if err := d.store.Get(d.ctx, root, obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// setMember represents the presence of a key in a set.
+func setMember(raw []byte) interface{} {
+	if raw == nil {
+		return nil
+	}
+	return true
+}
+
+// diffElement diffs the raw values of a collection entry, nil on the side
+// missing it.
+func (d *stateDiffer) diffElement(path string, linkA, linkB stateLink, a, b []byte) error {
+	if linkB.nested != nil {
+		rootA, err := decodeRoot(a)
+		if err != nil {
+			return err
+		}
+		rootB, err := decodeRoot(b)
+		if err != nil {
+			return err
+		}
+		return d.diffLink(path, *linkA.nested, *linkB.nested, rootA, rootB)
+	}
+	elemA, err := decodeElement(linkA, a)
+	if err != nil {
+		return err
+	}
+	elemB, err := decodeElement(linkB, b)
+	if err != nil {
+		return err
+	}
+	if elemA == nil || elemB == nil {
+		d.add(path, elemA, elemB)
+		return nil
+	}
+	return d.diffValue(path, reflect.ValueOf(elemA), reflect.ValueOf(elemB))
+}
+
+func decodeElement(link stateLink, raw []byte) (cbg.CBORUnmarshaler, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	elem := link.value()
+	if err := elem.UnmarshalCBOR(bytes.NewReader(raw)); err != nil {
+		return nil, err
+	}
+	return elem, nil
+}
+
+func decodeRoot(raw []byte) (cid.Cid, error) {
+	if raw == nil {
+		return cid.Undef, nil
+	}
+	var root cbg.CborCid
+	if err := root.UnmarshalCBOR(bytes.NewReader(raw)); err != nil {
+		return cid.Undef, err
+	}
+	return cid.Cid(root), nil
+}
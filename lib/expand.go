@@ -0,0 +1,446 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strconv"
+
+	address "github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/cbor"
+	init0 "github.com/filecoin-project/specs-actors/actors/builtin/init"
+	market0 "github.com/filecoin-project/specs-actors/actors/builtin/market"
+	miner0 "github.com/filecoin-project/specs-actors/actors/builtin/miner"
+	multisig0 "github.com/filecoin-project/specs-actors/actors/builtin/multisig"
+	paych0 "github.com/filecoin-project/specs-actors/actors/builtin/paych"
+	power0 "github.com/filecoin-project/specs-actors/actors/builtin/power"
+	verifreg0 "github.com/filecoin-project/specs-actors/actors/builtin/verifreg"
+	proof0 "github.com/filecoin-project/specs-actors/actors/runtime/proof"
+	adt0 "github.com/filecoin-project/specs-actors/actors/util/adt"
+	builtin2 "github.com/filecoin-project/specs-actors/v2/actors/builtin"
+	init2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/init"
+	market2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/market"
+	miner2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/miner"
+	multisig2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/multisig"
+	paych2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/paych"
+	power2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/power"
+	verifreg2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/verifreg"
+	proof2 "github.com/filecoin-project/specs-actors/v2/actors/runtime/proof"
+	states2 "github.com/filecoin-project/specs-actors/v2/actors/states"
+	adt2 "github.com/filecoin-project/specs-actors/v2/actors/util/adt"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+)
+
+type linkKind int
+
+const (
+	// linkObject is the root of a single object
+	linkObject linkKind = iota
+	// linkHAMT is the root of a HAMT
+	linkHAMT
+	// linkAMT is the root of an AMT
+	linkAMT
+	// linkSet is the root of a HAMT whose keys are its only content
+	linkSet
+)
+
+type keyKind int
+
+const (
+	keyAddress keyKind = iota
+	keyInt
+	keyUint
+	keyCid
+)
+
+// stateLink describes a field of an actor state object holding the root of
+// a linked object or collection.
+type stateLink struct {
+	kind linkKind
+	// key is the key encoding of HAMTs and sets
+	key keyKind
+	// value returns an empty object or collection element.  It is nil for
+	// collections of collections, see nested.
+	value func() cbg.CBORUnmarshaler
+	// nested describes the collection each element of a HAMT is the root of,
+	// such as the AMTs of a multimap.  Nested collections are expanded along
+	// with the collection holding them.
+	nested *stateLink
+}
+
+// stateLinks maps the state object types of every actors version to the
+// links held in their fields by field name.
+var stateLinks = make(map[reflect.Type]map[string]stateLink)
+
+func registerLinks(obj interface{}, links map[string]stateLink) {
+	stateLinks[reflect.TypeOf(obj)] = links
+}
+
+func objectLink(value func() cbg.CBORUnmarshaler) stateLink {
+	return stateLink{kind: linkObject, value: value}
+}
+
+func amtLink(value func() cbg.CBORUnmarshaler) stateLink {
+	return stateLink{kind: linkAMT, value: value}
+}
+
+func hamtLink(key keyKind, value func() cbg.CBORUnmarshaler) stateLink {
+	return stateLink{kind: linkHAMT, key: key, value: value}
+}
+
+func multimapLink(key keyKind, value func() cbg.CBORUnmarshaler) stateLink {
+	nested := amtLink(value)
+	return stateLink{kind: linkHAMT, key: key, nested: &nested}
+}
+
+func setMultimapLink(key, setKey keyKind) stateLink {
+	return stateLink{kind: linkHAMT, key: key, nested: &stateLink{kind: linkSet, key: setKey}}
+}
+
+func newBitField() cbg.CBORUnmarshaler     { return new(bitfield.BitField) }
+func newTokenAmount() cbg.CBORUnmarshaler  { return new(abi.TokenAmount) }
+func newStoragePower() cbg.CBORUnmarshaler { return new(abi.StoragePower) }
+func newActorID() cbg.CBORUnmarshaler      { return new(cbg.CborInt) }
+
+func init() {
+	// actors v0
+	registerLinks(&init0.State{}, map[string]stateLink{
+		"AddressMap": hamtLink(keyAddress, newActorID),
+	})
+	registerLinks(&power0.State{}, map[string]stateLink{
+		"CronEventQueue":       multimapLink(keyInt, func() cbg.CBORUnmarshaler { return new(power0.CronEvent) }),
+		"Claims":               hamtLink(keyAddress, func() cbg.CBORUnmarshaler { return new(power0.Claim) }),
+		"ProofValidationBatch": multimapLink(keyAddress, func() cbg.CBORUnmarshaler { return new(proof0.SealVerifyInfo) }),
+	})
+	registerLinks(&market0.State{}, map[string]stateLink{
+		"Proposals":        amtLink(func() cbg.CBORUnmarshaler { return new(market0.DealProposal) }),
+		"States":           amtLink(func() cbg.CBORUnmarshaler { return new(market0.DealState) }),
+		"PendingProposals": hamtLink(keyCid, func() cbg.CBORUnmarshaler { return new(market0.DealProposal) }),
+		"EscrowTable":      hamtLink(keyAddress, newTokenAmount),
+		"LockedTable":      hamtLink(keyAddress, newTokenAmount),
+		"DealOpsByEpoch":   setMultimapLink(keyUint, keyUint),
+	})
+	registerLinks(&miner0.State{}, map[string]stateLink{
+		"Info":                      objectLink(func() cbg.CBORUnmarshaler { return new(miner0.MinerInfo) }),
+		"VestingFunds":              objectLink(func() cbg.CBORUnmarshaler { return new(miner0.VestingFunds) }),
+		"PreCommittedSectors":       hamtLink(keyUint, func() cbg.CBORUnmarshaler { return new(miner0.SectorPreCommitOnChainInfo) }),
+		"PreCommittedSectorsExpiry": amtLink(newBitField),
+		"AllocatedSectors":          objectLink(newBitField),
+		"Sectors":                   amtLink(func() cbg.CBORUnmarshaler { return new(miner0.SectorOnChainInfo) }),
+		"Deadlines":                 objectLink(func() cbg.CBORUnmarshaler { return new(miner0.Deadlines) }),
+	})
+	registerLinks(&miner0.Deadlines{}, map[string]stateLink{
+		"Due": objectLink(func() cbg.CBORUnmarshaler { return new(miner0.Deadline) }),
+	})
+	registerLinks(&miner0.Deadline{}, map[string]stateLink{
+		"Partitions":        amtLink(func() cbg.CBORUnmarshaler { return new(miner0.Partition) }),
+		"ExpirationsEpochs": amtLink(newBitField),
+	})
+	registerLinks(&miner0.Partition{}, map[string]stateLink{
+		"ExpirationsEpochs": amtLink(func() cbg.CBORUnmarshaler { return new(miner0.ExpirationSet) }),
+		"EarlyTerminated":   amtLink(newBitField),
+	})
+	registerLinks(&multisig0.State{}, map[string]stateLink{
+		"PendingTxns": hamtLink(keyInt, func() cbg.CBORUnmarshaler { return new(multisig0.Transaction) }),
+	})
+	registerLinks(&paych0.State{}, map[string]stateLink{
+		"LaneStates": amtLink(func() cbg.CBORUnmarshaler { return new(paych0.LaneState) }),
+	})
+	registerLinks(&verifreg0.State{}, map[string]stateLink{
+		"Verifiers":       hamtLink(keyAddress, newStoragePower),
+		"VerifiedClients": hamtLink(keyAddress, newStoragePower),
+	})
+
+	// actors v2
+	registerLinks(&init2.State{}, map[string]stateLink{
+		"AddressMap": hamtLink(keyAddress, newActorID),
+	})
+	registerLinks(&power2.State{}, map[string]stateLink{
+		"CronEventQueue":       multimapLink(keyInt, func() cbg.CBORUnmarshaler { return new(power2.CronEvent) }),
+		"Claims":               hamtLink(keyAddress, func() cbg.CBORUnmarshaler { return new(power2.Claim) }),
+		"ProofValidationBatch": multimapLink(keyAddress, func() cbg.CBORUnmarshaler { return new(proof2.SealVerifyInfo) }),
+	})
+	registerLinks(&market2.State{}, map[string]stateLink{
+		"Proposals":        amtLink(func() cbg.CBORUnmarshaler { return new(market2.DealProposal) }),
+		"States":           amtLink(func() cbg.CBORUnmarshaler { return new(market2.DealState) }),
+		"PendingProposals": hamtLink(keyCid, func() cbg.CBORUnmarshaler { return new(market2.DealProposal) }),
+		"EscrowTable":      hamtLink(keyAddress, newTokenAmount),
+		"LockedTable":      hamtLink(keyAddress, newTokenAmount),
+		"DealOpsByEpoch":   setMultimapLink(keyUint, keyUint),
+	})
+	registerLinks(&miner2.State{}, map[string]stateLink{
+		"Info":                      objectLink(func() cbg.CBORUnmarshaler { return new(miner2.MinerInfo) }),
+		"VestingFunds":              objectLink(func() cbg.CBORUnmarshaler { return new(miner2.VestingFunds) }),
+		"PreCommittedSectors":       hamtLink(keyUint, func() cbg.CBORUnmarshaler { return new(miner2.SectorPreCommitOnChainInfo) }),
+		"PreCommittedSectorsExpiry": amtLink(newBitField),
+		"AllocatedSectors":          objectLink(newBitField),
+		"Sectors":                   amtLink(func() cbg.CBORUnmarshaler { return new(miner2.SectorOnChainInfo) }),
+		"Deadlines":                 objectLink(func() cbg.CBORUnmarshaler { return new(miner2.Deadlines) }),
+	})
+	registerLinks(&miner2.Deadlines{}, map[string]stateLink{
+		"Due": objectLink(func() cbg.CBORUnmarshaler { return new(miner2.Deadline) }),
+	})
+	registerLinks(&miner2.Deadline{}, map[string]stateLink{
+		"Partitions":        amtLink(func() cbg.CBORUnmarshaler { return new(miner2.Partition) }),
+		"ExpirationsEpochs": amtLink(newBitField),
+	})
+	registerLinks(&miner2.Partition{}, map[string]stateLink{
+		"ExpirationsEpochs": amtLink(func() cbg.CBORUnmarshaler { return new(miner2.ExpirationSet) }),
+		"EarlyTerminated":   amtLink(newBitField),
+	})
+	registerLinks(&multisig2.State{}, map[string]stateLink{
+		"PendingTxns": hamtLink(keyInt, func() cbg.CBORUnmarshaler { return new(multisig2.Transaction) }),
+	})
+	registerLinks(&paych2.State{}, map[string]stateLink{
+		"LaneStates": amtLink(func() cbg.CBORUnmarshaler { return new(paych2.LaneState) }),
+	})
+	registerLinks(&verifreg2.State{}, map[string]stateLink{
+		"Verifiers":       hamtLink(keyAddress, newStoragePower),
+		"VerifiedClients": hamtLink(keyAddress, newStoragePower),
+	})
+}
+
+// jsonField is a field of a jsonObject.
+type jsonField struct {
+	Key   string
+	Value interface{}
+}
+
+// jsonObject marshals to a JSON object keeping the order of its fields.
+type jsonObject []jsonField
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(f.Key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		value, err := json.Marshal(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// expander loads the linked objects and collections of one actors version.
+type expander struct {
+	ctx     context.Context
+	store   cbornode.IpldStore
+	version ActorsVersion
+}
+
+// ExpandActorState decodes the state of a like LoadActorState and replaces
+// the roots of the HAMTs, AMTs and linked objects it holds with their
+// decoded contents, to depth levels of nesting.  HAMTs and AMTs become JSON
+// objects keyed by their keys in iteration order.  Depth zero returns the
+// decoded state as is.
+func ExpandActorState(ctx context.Context, store cbornode.IpldStore, a *states2.Actor, depth int) (interface{}, error) {
+	st, err := LoadActorState(ctx, store, a)
+	if err != nil {
+		return nil, err
+	}
+	e := &expander{ctx: ctx, store: store, version: ActorsVersion0}
+	if builtin2.IsBuiltinActor(a.Code) {
+		e.version = ActorsVersion2
+	}
+	return e.expand(st, depth)
+}
+
+// expand returns obj with the links of its fields expanded to depth.
+func (e *expander) expand(obj interface{}, depth int) (interface{}, error) {
+	links, found := stateLinks[reflect.TypeOf(obj)]
+	if depth <= 0 || !found {
+		return obj, nil
+	}
+	v := reflect.ValueOf(obj).Elem()
+	var out jsonObject
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Name
+		field := v.Field(i).Interface()
+		link, isLink := links[name]
+		if !isLink {
+			out = append(out, jsonField{name, field})
+			continue
+		}
+		var expanded interface{}
+		var err error
+		switch root := field.(type) {
+		case cid.Cid:
+			expanded, err = e.load(link, root, depth-1)
+		case *cid.Cid:
+			if root != nil {
+				expanded, err = e.load(link, *root, depth-1)
+			}
+		default:
+			// Arrays of links such as miner Deadlines.Due
+			roots := v.Field(i)
+			if roots.Kind() != reflect.Array || roots.Type().Elem() != reflect.TypeOf(cid.Cid{}) {
+				return nil, xerrors.Errorf("field %s of %T is not a link", name, obj)
+			}
+			elems := make([]interface{}, roots.Len())
+			for j := range elems {
+				if elems[j], err = e.load(link, roots.Index(j).Interface().(cid.Cid), depth-1); err != nil {
+					break
+				}
+			}
+			expanded = elems
+		}
+		if err != nil {
+			return nil, xerrors.Errorf("failed to expand %s: %w", name, err)
+		}
+		out = append(out, jsonField{name, expanded})
+	}
+	return out, nil
+}
+
+// load decodes the object or collection at root and expands its elements to
+// depth.
+func (e *expander) load(link stateLink, root cid.Cid, depth int) (interface{}, error) {
+	switch link.kind {
+	case linkObject:
+		obj := link.value()
+		if err := e.store.Get(e.ctx, root, obj); err != nil {
+			return nil, err
+		}
+		return e.expand(obj, depth)
+	case linkAMT:
+		var out jsonObject
+		err := e.forEachAMT(root, func(i int64, raw []byte) error {
+			elem, err := e.decode(link, raw, depth)
+			if err != nil {
+				return err
+			}
+			out = append(out, jsonField{strconv.FormatInt(i, 10), elem})
+			return nil
+		})
+		return out, err
+	case linkHAMT:
+		var out jsonObject
+		err := e.forEachHAMT(root, func(k string, raw []byte) error {
+			key, err := parseKey(link.key, k)
+			if err != nil {
+				return err
+			}
+			elem, err := e.decode(link, raw, depth)
+			if err != nil {
+				return err
+			}
+			out = append(out, jsonField{key, elem})
+			return nil
+		})
+		return out, err
+	case linkSet:
+		var out []string
+		err := e.forEachHAMT(root, func(k string, _ []byte) error {
+			key, err := parseKey(link.key, k)
+			if err != nil {
+				return err
+			}
+			out = append(out, key)
+			return nil
+		})
+		return out, err
+	}
+	return nil, xerrors.Errorf("unknown link kind %d", link.kind)
+}
+
+// decode decodes a collection element, loading the collection it is the
+// root of for collections of collections.
+func (e *expander) decode(link stateLink, raw []byte, depth int) (interface{}, error) {
+	if link.nested != nil {
+		var root cbg.CborCid
+		if err := root.UnmarshalCBOR(bytes.NewReader(raw)); err != nil {
+			return nil, err
+		}
+		return e.load(*link.nested, cid.Cid(root), depth)
+	}
+	elem := link.value()
+	if err := elem.UnmarshalCBOR(bytes.NewReader(raw)); err != nil {
+		return nil, err
+	}
+	return e.expand(elem, depth)
+}
+
+func (e *expander) forEachAMT(root cid.Cid, cb func(i int64, raw []byte) error) error {
+	var arr interface {
+		ForEach(out cbor.Unmarshaler, fn func(i int64) error) error
+	}
+	var err error
+	if e.version == ActorsVersion0 {
+		arr, err = adt0.AsArray(adt0.WrapStore(e.ctx, e.store), root)
+	} else {
+		arr, err = adt2.AsArray(adt2.WrapStore(e.ctx, e.store), root)
+	}
+	if err != nil {
+		return err
+	}
+	var d cbg.Deferred
+	return arr.ForEach(&d, func(i int64) error {
+		return cb(i, d.Raw)
+	})
+}
+
+func (e *expander) forEachHAMT(root cid.Cid, cb func(k string, raw []byte) error) error {
+	var m interface {
+		ForEach(out cbor.Unmarshaler, fn func(key string) error) error
+	}
+	var err error
+	if e.version == ActorsVersion0 {
+		m, err = adt0.AsMap(adt0.WrapStore(e.ctx, e.store), root)
+	} else {
+		m, err = adt2.AsMap(adt2.WrapStore(e.ctx, e.store), root)
+	}
+	if err != nil {
+		return err
+	}
+	var d cbg.Deferred
+	return m.ForEach(&d, func(k string) error {
+		return cb(k, d.Raw)
+	})
+}
+
+func parseKey(kind keyKind, k string) (string, error) {
+	switch kind {
+	case keyAddress:
+		addr, err := address.NewFromBytes([]byte(k))
+		if err != nil {
+			return "", err
+		}
+		return addr.String(), nil
+	case keyInt:
+		i, err := abi.ParseIntKey(k)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(i, 10), nil
+	case keyUint:
+		u, err := abi.ParseUIntKey(k)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatUint(u, 10), nil
+	case keyCid:
+		c, err := cid.Cast([]byte(k))
+		if err != nil {
+			return "", err
+		}
+		return c.String(), nil
+	}
+	return "", xerrors.Errorf("unknown key kind %d", kind)
+}
@@ -0,0 +1,40 @@
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	cid "github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+)
+
+// GoldenEntry records the expected output of migrating one input state root
+// at the given epoch.
+type GoldenEntry struct {
+	Input  cid.Cid
+	Epoch  abi.ChainEpoch
+	Output cid.Cid
+}
+
+// LoadGoldenFile reads a JSON array of golden entries from path.
+func LoadGoldenFile(path string) ([]GoldenEntry, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []GoldenEntry
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil, xerrors.Errorf("failed to decode golden file %s: %w", path, err)
+	}
+	return entries, nil
+}
+
+// WriteGoldenFile writes golden entries to path as an indented JSON array.
+func WriteGoldenFile(path string, entries []GoldenEntry) error {
+	raw, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(raw, '\n'), 0644)
+}
@@ -0,0 +1,91 @@
+package lib
+
+import (
+	address "github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	builtin2 "github.com/filecoin-project/specs-actors/v2/actors/builtin"
+	account2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/account"
+	cron2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/cron"
+	init2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/init"
+	market2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/market"
+	miner2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/miner"
+	multisig2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/multisig"
+	paych2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/paych"
+	power2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/power"
+	verifreg2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/verifreg"
+	states2 "github.com/filecoin-project/specs-actors/v2/actors/states"
+	adt2 "github.com/filecoin-project/specs-actors/v2/actors/util/adt"
+	"golang.org/x/xerrors"
+)
+
+// CheckActorInvariants runs the specs-actors v2 invariant checks of a single
+// actor.  Cross-actor checks are not run.
+func CheckActorInvariants(store adt2.Store, addr address.Address, a *states2.Actor, priorEpoch abi.ChainEpoch) (*builtin2.MessageAccumulator, error) {
+	_, msgs, err := checkActorInvariants(store, addr, a, priorEpoch)
+	return msgs, err
+}
+
+// checkActorInvariants mirrors the per-actor switch of
+// states2.CheckStateInvariants and also returns the actor's state summary
+// for use in cross-actor checks.
+func checkActorInvariants(store adt2.Store, addr address.Address, a *states2.Actor, priorEpoch abi.ChainEpoch) (interface{}, *builtin2.MessageAccumulator, error) {
+	switch a.Code {
+	case builtin2.SystemActorCodeID, builtin2.RewardActorCodeID:
+		return nil, &builtin2.MessageAccumulator{}, nil
+	case builtin2.InitActorCodeID:
+		var st init2.State
+		if err := store.Get(store.Context(), a.Head, &st); err != nil {
+			return nil, nil, err
+		}
+		return init2.CheckStateInvariants(&st, store)
+	case builtin2.CronActorCodeID:
+		var st cron2.State
+		if err := store.Get(store.Context(), a.Head, &st); err != nil {
+			return nil, nil, err
+		}
+		return cron2.CheckStateInvariants(&st, store)
+	case builtin2.AccountActorCodeID:
+		var st account2.State
+		if err := store.Get(store.Context(), a.Head, &st); err != nil {
+			return nil, nil, err
+		}
+		return account2.CheckStateInvariants(&st, addr)
+	case builtin2.StoragePowerActorCodeID:
+		var st power2.State
+		if err := store.Get(store.Context(), a.Head, &st); err != nil {
+			return nil, nil, err
+		}
+		return power2.CheckStateInvariants(&st, store)
+	case builtin2.StorageMinerActorCodeID:
+		var st miner2.State
+		if err := store.Get(store.Context(), a.Head, &st); err != nil {
+			return nil, nil, err
+		}
+		return miner2.CheckStateInvariants(&st, store, a.Balance)
+	case builtin2.StorageMarketActorCodeID:
+		var st market2.State
+		if err := store.Get(store.Context(), a.Head, &st); err != nil {
+			return nil, nil, err
+		}
+		return market2.CheckStateInvariants(&st, store, a.Balance, priorEpoch)
+	case builtin2.PaymentChannelActorCodeID:
+		var st paych2.State
+		if err := store.Get(store.Context(), a.Head, &st); err != nil {
+			return nil, nil, err
+		}
+		return paych2.CheckStateInvariants(&st, store, a.Balance)
+	case builtin2.MultisigActorCodeID:
+		var st multisig2.State
+		if err := store.Get(store.Context(), a.Head, &st); err != nil {
+			return nil, nil, err
+		}
+		return multisig2.CheckStateInvariants(&st, store)
+	case builtin2.VerifiedRegistryActorCodeID:
+		var st verifreg2.State
+		if err := store.Get(store.Context(), a.Head, &st); err != nil {
+			return nil, nil, err
+		}
+		return verifreg2.CheckStateInvariants(&st, store)
+	}
+	return nil, nil, xerrors.Errorf("unexpected actor code CID %v for address %v", a.Code, addr)
+}
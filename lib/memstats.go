@@ -0,0 +1,125 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"runtime"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MemStats summarizes memory use over a sampled interval.  PeakRSS is the
+// kernel's resident set high water mark, the peak heap values are the maxima
+// seen across samples and the others are deltas over the interval.
+type MemStats struct {
+	PeakRSS       uint64
+	PeakHeapAlloc uint64
+	PeakHeapInuse uint64
+	PeakHeapSys   uint64
+	TotalAlloc    uint64
+	Mallocs       uint64
+	NumGC         uint32
+	PauseTotal    time.Duration
+}
+
+// MemSampler samples Go heap statistics in the background until stopped and
+// reads the peak RSS the kernel recorded meanwhile.
+type MemSampler struct {
+	lk    sync.Mutex
+	stats MemStats
+	start runtime.MemStats
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// StartMemSampler begins sampling every interval.
+func StartMemSampler(interval time.Duration) *MemSampler {
+	s := &MemSampler{done: make(chan struct{})}
+	resetPeakRSS()
+	runtime.ReadMemStats(&s.start)
+	s.sample()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.sample()
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// Stop takes a final sample and returns the statistics of the whole interval.
+func (s *MemSampler) Stop() MemStats {
+	close(s.done)
+	s.wg.Wait()
+	end := s.sample()
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.stats.TotalAlloc = end.TotalAlloc - s.start.TotalAlloc
+	s.stats.Mallocs = end.Mallocs - s.start.Mallocs
+	s.stats.NumGC = end.NumGC - s.start.NumGC
+	s.stats.PauseTotal = time.Duration(end.PauseTotalNs - s.start.PauseTotalNs)
+	s.stats.PeakRSS = peakRSS()
+	return s.stats
+}
+
+func (s *MemSampler) sample() runtime.MemStats {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if ms.HeapAlloc > s.stats.PeakHeapAlloc {
+		s.stats.PeakHeapAlloc = ms.HeapAlloc
+	}
+	if ms.HeapInuse > s.stats.PeakHeapInuse {
+		s.stats.PeakHeapInuse = ms.HeapInuse
+	}
+	if ms.HeapSys > s.stats.PeakHeapSys {
+		s.stats.PeakHeapSys = ms.HeapSys
+	}
+	return ms
+}
+
+// resetPeakRSS resets the kernel's RSS high water mark of this process so
+// that peakRSS covers only what follows.  Where the reset is unsupported the
+// peak covers the whole life of the process.
+func resetPeakRSS() {
+	_ = ioutil.WriteFile("/proc/self/clear_refs", []byte("5"), 0)
+}
+
+// peakRSS returns the peak resident set size of this process in bytes, read
+// as VmHWM from /proc/self/status, or from getrusage where procfs is
+// unavailable.
+func peakRSS() uint64 {
+	raw, err := ioutil.ReadFile("/proc/self/status")
+	if err == nil {
+		scanner := bufio.NewScanner(bytes.NewReader(raw))
+		for scanner.Scan() {
+			fields := bytes.Fields(scanner.Bytes())
+			if len(fields) < 2 || string(fields[0]) != "VmHWM:" {
+				continue
+			}
+			if kb, err := strconv.ParseUint(string(fields[1]), 10, 64); err == nil {
+				return kb * 1024
+			}
+		}
+	}
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0
+	}
+	// Linux reports kilobytes
+	return uint64(usage.Maxrss) * 1024
+}
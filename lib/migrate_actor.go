@@ -0,0 +1,111 @@
+package lib
+
+import (
+	"context"
+
+	address "github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	builtin0 "github.com/filecoin-project/specs-actors/actors/builtin"
+	power0 "github.com/filecoin-project/specs-actors/actors/builtin/power"
+	states0 "github.com/filecoin-project/specs-actors/actors/states"
+	adt0 "github.com/filecoin-project/specs-actors/actors/util/adt"
+	migration2 "github.com/filecoin-project/specs-actors/v2/actors/migration"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"golang.org/x/xerrors"
+)
+
+// v0MigrationSingletons are the actors MigrateStateTree reads or writes
+// regardless of which other actors are in the tree.
+var v0MigrationSingletons = []address.Address{
+	builtin0.SystemActorAddr,
+	builtin0.InitActorAddr,
+	builtin0.RewardActorAddr,
+	builtin0.CronActorAddr,
+	builtin0.StoragePowerActorAddr,
+	builtin0.VerifiedRegistryActorAddr,
+	builtin0.BurntFundsActorAddr,
+}
+
+// migrateActorV0ToV2 migrates a single actor of the v0 tree at stateRootIn.
+// specs-actors does not export its per-actor migrators so this builds a
+// reduced input tree holding only the actor and the singletons the migration
+// depends on, with power claims cut down to the actor's own claim, and
+// migrates that.  The returned tree holds the migrated actor.
+func migrateActorV0ToV2(ctx context.Context, store cbornode.IpldStore, stateRootIn cid.Cid, priorEpoch abi.ChainEpoch, addr address.Address) (cid.Cid, error) {
+	adtStore := adt0.WrapStore(ctx, store)
+	actorsIn, err := states0.LoadTree(adtStore, stateRootIn)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if addr == builtin0.StoragePowerActorAddr {
+		// Migrating power claims loads every miner, only the whole tree will do.
+		return migration2.MigrateStateTree(ctx, store, stateRootIn, priorEpoch, migration2.DefaultConfig())
+	}
+	target, found, err := actorsIn.GetActor(addr)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if !found {
+		return cid.Undef, xerrors.Errorf("actor %s not found in %s", addr, stateRootIn)
+	}
+
+	reduced, err := states0.NewTree(adtStore)
+	if err != nil {
+		return cid.Undef, err
+	}
+	for _, singleton := range v0MigrationSingletons {
+		a, found, err := actorsIn.GetActor(singleton)
+		if err != nil {
+			return cid.Undef, err
+		}
+		if !found {
+			return cid.Undef, xerrors.Errorf("singleton actor %s not found in %s", singleton, stateRootIn)
+		}
+		if singleton == builtin0.StoragePowerActorAddr {
+			if a.Head, err = filterPowerClaims(ctx, store, a.Head, addr); err != nil {
+				return cid.Undef, xerrors.Errorf("failed to filter power claims: %w", err)
+			}
+		}
+		if err := reduced.SetActor(singleton, a); err != nil {
+			return cid.Undef, err
+		}
+	}
+	if err := reduced.SetActor(addr, target); err != nil {
+		return cid.Undef, err
+	}
+	reducedRoot, err := reduced.Flush()
+	if err != nil {
+		return cid.Undef, err
+	}
+	return migration2.MigrateStateTree(ctx, store, reducedRoot, priorEpoch, migration2.DefaultConfig())
+}
+
+// filterPowerClaims writes a copy of the v0 power state at head whose claims
+// table only holds the claim of keep, if any, and returns its head.
+func filterPowerClaims(ctx context.Context, store cbornode.IpldStore, head cid.Cid, keep address.Address) (cid.Cid, error) {
+	adtStore := adt0.WrapStore(ctx, store)
+	var st power0.State
+	if err := store.Get(ctx, head, &st); err != nil {
+		return cid.Undef, err
+	}
+	claims, err := adt0.AsMap(adtStore, st.Claims)
+	if err != nil {
+		return cid.Undef, err
+	}
+	filtered := adt0.MakeEmptyMap(adtStore)
+	var claim power0.Claim
+	found, err := claims.Get(abi.AddrKey(keep), &claim)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if found {
+		if err := filtered.Put(abi.AddrKey(keep), &claim); err != nil {
+			return cid.Undef, err
+		}
+	}
+	if st.Claims, err = filtered.Root(); err != nil {
+		return cid.Undef, err
+	}
+	return store.Put(ctx, &st)
+}
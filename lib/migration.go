@@ -0,0 +1,100 @@
+package lib
+
+import (
+	"context"
+	"sort"
+
+	address "github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	builtin2 "github.com/filecoin-project/specs-actors/v2/actors/builtin"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"golang.org/x/xerrors"
+)
+
+// MigrateFunc migrates the actors tree at stateRootIn, created at priorEpoch,
+// and returns the root of the migrated actors tree.
+type MigrateFunc func(ctx context.Context, store cbornode.IpldStore, stateRootIn cid.Cid, priorEpoch abi.ChainEpoch) (cid.Cid, error)
+
+// MigrateActorFunc migrates the actor at addr in the actors tree at
+// stateRootIn and returns the root of an actors tree holding the migrated
+// actor.  The returned tree need not hold any other actor of the input.
+type MigrateActorFunc func(ctx context.Context, store cbornode.IpldStore, stateRootIn cid.Cid, priorEpoch abi.ChainEpoch, addr address.Address) (cid.Cid, error)
+
+// ValidateFunc checks invariants of the actors tree at stateRoot, which
+// belongs to network.
+type ValidateFunc func(ctx context.Context, store cbornode.IpldStore, stateRoot cid.Cid, priorEpoch abi.ChainEpoch, network Network) (*builtin2.MessageAccumulator, error)
+
+// Migration is a named state tree migration between two actors versions.
+type Migration struct {
+	Name          string
+	InputVersion  ActorsVersion
+	OutputVersion ActorsVersion
+	Migrate       MigrateFunc
+	// MigrateSerially migrates the actors one at a time on a single worker,
+	// as needed to time each actor.  It may be nil if the migration cannot
+	// be run serially.
+	MigrateSerially MigrateFunc
+	// MigrateActor migrates a single actor.  It may be nil if the migration
+	// cannot be run on one actor.
+	MigrateActor MigrateActorFunc
+	// ValidateInput checks the invariants of a tree before migration.  It
+	// may be nil if the input version has no invariant checks.
+	ValidateInput ValidateFunc
+	// Validate checks the invariants of a migrated tree.  It may be nil if
+	// the output version has no invariant checks.
+	Validate ValidateFunc
+}
+
+// CheckInput returns an error if the actors tree at stateRootIn is not of the
+// migration's input version.
+func (m Migration) CheckInput(ctx context.Context, store cbornode.IpldStore, stateRootIn cid.Cid) error {
+	return checkVersion(ctx, store, stateRootIn, m.InputVersion)
+}
+
+// CheckOutput returns an error if the actors tree at stateRootOut is not of
+// the migration's output version.
+func (m Migration) CheckOutput(ctx context.Context, store cbornode.IpldStore, stateRootOut cid.Cid) error {
+	return checkVersion(ctx, store, stateRootOut, m.OutputVersion)
+}
+
+func checkVersion(ctx context.Context, store cbornode.IpldStore, stateRoot cid.Cid, expected ActorsVersion) error {
+	version, err := DetectActorsVersion(ctx, store, stateRoot)
+	if err != nil {
+		return err
+	}
+	if version != expected {
+		return xerrors.Errorf("state %s has actors version %s, expected %s", stateRoot, version, expected)
+	}
+	return nil
+}
+
+var migrations = make(map[string]Migration)
+
+// RegisterMigration makes a migration available by name.  It panics if a
+// migration with the same name is already registered.
+func RegisterMigration(m Migration) {
+	if _, found := migrations[m.Name]; found {
+		panic("migration registered twice: " + m.Name)
+	}
+	migrations[m.Name] = m
+}
+
+// GetMigration returns the migration registered under name.
+func GetMigration(name string) (Migration, error) {
+	m, found := migrations[name]
+	if !found {
+		return Migration{}, xerrors.Errorf("unknown migration %q, known migrations: %v", name, MigrationNames())
+	}
+	return m, nil
+}
+
+// MigrationNames returns the names of all registered migrations in order.
+func MigrationNames() []string {
+	names := make([]string, 0, len(migrations))
+	for name := range migrations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,204 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	address "github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	builtin0 "github.com/filecoin-project/specs-actors/actors/builtin"
+	states0 "github.com/filecoin-project/specs-actors/actors/states"
+	adt0 "github.com/filecoin-project/specs-actors/actors/util/adt"
+	migration2 "github.com/filecoin-project/specs-actors/v2/actors/migration"
+	states2 "github.com/filecoin-project/specs-actors/v2/actors/states"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"golang.org/x/xerrors"
+)
+
+// cacheableCodes are the v0 actor codes whose v0 to v2 migration is a
+// function of the actor's head alone, so their results can be reused at any
+// later epoch.  Miners are left out as their migration depends on the prior
+// epoch and feeds claim and cron updates to the power actor migration, and
+// the power, reward, verified registry and market actors are always migrated
+// with the tree they read.  The migrated actor keeps its nonce and balance.
+var cacheableCodes = map[cid.Cid]bool{
+	builtin0.AccountActorCodeID:        true,
+	builtin0.MultisigActorCodeID:       true,
+	builtin0.PaymentChannelActorCodeID: true,
+}
+
+// cacheKey identifies an input actor state.  The code is part of the key as
+// states of different actor types may encode identically.
+type cacheKey struct {
+	Code cid.Cid
+	Head cid.Cid
+}
+
+// MigrationCache remembers the v2 head migrated from the head of every
+// cacheable v0 actor, see cacheableCodes.  A pre-migration run some epochs
+// before an upgrade fills the cache, and Migrate then only migrates the
+// actors whose results cannot be reused.
+type MigrationCache struct {
+	outputs map[cacheKey]cid.Cid
+}
+
+// CacheReport summarizes the cache lookups of one migration.
+type CacheReport struct {
+	Actors       int
+	Hits         int
+	ActorsByType map[string]int
+	HitsByType   map[string]int
+}
+
+func NewMigrationCache() *MigrationCache {
+	return &MigrationCache{outputs: make(map[cacheKey]cid.Cid)}
+}
+
+// cacheEntry is the JSON encoding of one cached result.
+type cacheEntry struct {
+	Code   cid.Cid
+	Head   cid.Cid
+	Output cid.Cid
+}
+
+// LoadMigrationCache reads a cache saved with Save.  A missing file yields an
+// empty cache.
+func LoadMigrationCache(path string) (*MigrationCache, error) {
+	mc := NewMigrationCache()
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return mc, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []cacheEntry
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil, xerrors.Errorf("failed to decode migration cache %s: %w", path, err)
+	}
+	for _, e := range entries {
+		mc.outputs[cacheKey{e.Code, e.Head}] = e.Output
+	}
+	return mc, nil
+}
+
+// Save writes the cache to path as a JSON list of input codes and heads with
+// their output heads.
+func (mc *MigrationCache) Save(path string) error {
+	entries := make([]cacheEntry, 0, len(mc.outputs))
+	for key, out := range mc.outputs {
+		entries = append(entries, cacheEntry{key.Code, key.Head, out})
+	}
+	raw, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, raw, 0644)
+}
+
+func (mc *MigrationCache) Len() int {
+	return len(mc.outputs)
+}
+
+// Migrate migrates the v0 actors tree at stateRootIn to v2 reusing cached
+// results.  Like migrateActorV0ToV2 it builds a reduced input tree, holding
+// every actor without a cached result, migrates it, and then adds the cached
+// actors to the output.  The results of cacheable actors migrated are added
+// to the cache.  The output root is the one MigrateStateTree returns for the
+// full tree.
+func (mc *MigrationCache) Migrate(ctx context.Context, store cbornode.IpldStore, stateRootIn cid.Cid, priorEpoch abi.ChainEpoch) (cid.Cid, *CacheReport, error) {
+	if err := checkVersion(ctx, store, stateRootIn, ActorsVersion0); err != nil {
+		return cid.Undef, nil, err
+	}
+	adtStore := adt0.WrapStore(ctx, store)
+	actorsIn, err := states0.LoadTree(adtStore, stateRootIn)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	reduced, err := states0.NewTree(adtStore)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+
+	report := &CacheReport{
+		ActorsByType: make(map[string]int),
+		HitsByType:   make(map[string]int),
+	}
+	// The burnt funds account is always migrated as the migration pays miner
+	// debts from its balance.
+	singletons := make(map[address.Address]bool)
+	for _, addr := range v0MigrationSingletons {
+		singletons[addr] = true
+	}
+	hits := make(map[address.Address]*states2.Actor)
+	misses := make(map[address.Address]cacheKey)
+	if err := actorsIn.ForEach(func(addr address.Address, a *states0.Actor) error {
+		actorType := ActorTypeName(a.Code)
+		report.Actors++
+		report.ActorsByType[actorType]++
+		if !cacheableCodes[a.Code] || singletons[addr] {
+			return reduced.SetActor(addr, a)
+		}
+		key := cacheKey{a.Code, a.Head}
+		out, hit := mc.outputs[key]
+		if !hit {
+			misses[addr] = key
+			return reduced.SetActor(addr, a)
+		}
+		report.Hits++
+		report.HitsByType[actorType]++
+		hits[addr] = &states2.Actor{
+			Code:       migratedCodes[a.Code],
+			Head:       out,
+			CallSeqNum: a.CallSeqNum,
+			Balance:    a.Balance,
+		}
+		return nil
+	}); err != nil {
+		return cid.Undef, nil, xerrors.Errorf("failed to load migration input %s: %w", stateRootIn, err)
+	}
+	reducedRoot, err := reduced.Flush()
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+
+	reducedOut, err := migration2.MigrateStateTree(ctx, store, reducedRoot, priorEpoch, migration2.DefaultConfig())
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	actorsOut, err := states2.LoadTree(adtStore, reducedOut)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	for addr, key := range misses {
+		a, found, err := actorsOut.GetActor(addr)
+		if err != nil {
+			return cid.Undef, nil, err
+		}
+		if !found {
+			return cid.Undef, nil, xerrors.Errorf("actor %s missing from migration output %s", addr, reducedOut)
+		}
+		mc.outputs[key] = a.Head
+	}
+	for addr, a := range hits {
+		if err := actorsOut.SetActor(addr, a); err != nil {
+			return cid.Undef, nil, err
+		}
+	}
+	stateRootOut, err := actorsOut.Flush()
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	return stateRootOut, report, nil
+}
+
+// HitRate returns the fraction of input actors found in the cache.
+func (r *CacheReport) HitRate() float64 {
+	if r.Actors == 0 {
+		return 0
+	}
+	return float64(r.Hits) / float64(r.Actors)
+}
@@ -0,0 +1,137 @@
+package lib
+
+import (
+	"context"
+	"testing"
+
+	address "github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/cbor"
+	builtin0 "github.com/filecoin-project/specs-actors/actors/builtin"
+	account0 "github.com/filecoin-project/specs-actors/actors/builtin/account"
+	cron0 "github.com/filecoin-project/specs-actors/actors/builtin/cron"
+	init0 "github.com/filecoin-project/specs-actors/actors/builtin/init"
+	market0 "github.com/filecoin-project/specs-actors/actors/builtin/market"
+	multisig0 "github.com/filecoin-project/specs-actors/actors/builtin/multisig"
+	power0 "github.com/filecoin-project/specs-actors/actors/builtin/power"
+	reward0 "github.com/filecoin-project/specs-actors/actors/builtin/reward"
+	system0 "github.com/filecoin-project/specs-actors/actors/builtin/system"
+	verifreg0 "github.com/filecoin-project/specs-actors/actors/builtin/verifreg"
+	states0 "github.com/filecoin-project/specs-actors/actors/states"
+	adt0 "github.com/filecoin-project/specs-actors/actors/util/adt"
+	migration2 "github.com/filecoin-project/specs-actors/v2/actors/migration"
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+)
+
+// testV0Tree builds a minimal v0 actors tree with the singletons the
+// migration needs, and returns a function setting further actors.
+func testV0Tree(t *testing.T, ctx context.Context, store cbornode.IpldStore) (*states0.Tree, func(addr address.Address, code cid.Cid, state cbor.Marshaler, balance abi.TokenAmount)) {
+	adtStore := adt0.WrapStore(ctx, store)
+	tree, err := states0.NewTree(adtStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := func(addr address.Address, code cid.Cid, state cbor.Marshaler, balance abi.TokenAmount) {
+		head, err := store.Put(ctx, state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.SetActor(addr, &states0.Actor{Code: code, Head: head, Balance: balance}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	emptyMap, err := adt0.MakeEmptyMap(adtStore).Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	emptyArray, err := adt0.MakeEmptyArray(adtStore).Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	emptyMultimap, err := adt0.MakeEmptyMultimap(adtStore).Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootKey := testIDAddress(80)
+	set(builtin0.SystemActorAddr, builtin0.SystemActorCodeID, &system0.State{}, big.Zero())
+	set(builtin0.InitActorAddr, builtin0.InitActorCodeID, init0.ConstructState(emptyMap, "test"), big.Zero())
+	set(builtin0.RewardActorAddr, builtin0.RewardActorCodeID, reward0.ConstructState(abi.NewStoragePower(0)), builtin0.TotalFilecoin)
+	set(builtin0.CronActorAddr, builtin0.CronActorCodeID, cron0.ConstructState(cron0.BuiltInEntries()), big.Zero())
+	set(builtin0.StoragePowerActorAddr, builtin0.StoragePowerActorCodeID, power0.ConstructState(emptyMap, emptyMultimap), big.Zero())
+	set(builtin0.StorageMarketActorAddr, builtin0.StorageMarketActorCodeID, market0.ConstructState(emptyArray, emptyMap, emptyMultimap), big.Zero())
+	set(rootKey, builtin0.AccountActorCodeID, &account0.State{Address: rootKey}, big.Zero())
+	set(builtin0.VerifiedRegistryActorAddr, builtin0.VerifiedRegistryActorCodeID, verifreg0.ConstructState(emptyMap, rootKey), big.Zero())
+	set(builtin0.BurntFundsActorAddr, builtin0.AccountActorCodeID, &account0.State{Address: builtin0.BurntFundsActorAddr}, big.NewInt(1000))
+	return tree, set
+}
+
+func newMemCborStore() cbornode.IpldStore {
+	return cbornode.NewCborStore(blockstore.NewBlockstore(dssync.MutexWrap(datastore.NewMapDatastore())))
+}
+
+func testIDAddress(id uint64) address.Address {
+	addr, err := address.NewIDAddress(id)
+	if err != nil {
+		panic(err)
+	}
+	return addr
+}
+
+func TestMigrationCacheMatchesFullMigration(t *testing.T) {
+	ctx := context.Background()
+	store := newMemCborStore()
+	tree, set := testV0Tree(t, ctx, store)
+	for i := uint64(0); i < 20; i++ {
+		addr := testIDAddress(1000 + i)
+		set(addr, builtin0.AccountActorCodeID, &account0.State{Address: addr}, big.NewInt(int64(i)))
+	}
+	emptyMap, err := adt0.MakeEmptyMap(adt0.WrapStore(ctx, store)).Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	set(testIDAddress(2000), builtin0.MultisigActorCodeID, &multisig0.State{
+		Signers:               []address.Address{testIDAddress(1000)},
+		NumApprovalsThreshold: 1,
+		InitialBalance:        big.Zero(),
+		PendingTxns:           emptyMap,
+	}, big.NewInt(5))
+
+	mc := NewMigrationCache()
+	migrate := func(priorEpoch abi.ChainEpoch) *CacheReport {
+		root, err := tree.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := migration2.MigrateStateTree(ctx, store, root, priorEpoch, migration2.DefaultConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, report, err := mc.Migrate(ctx, store, root, priorEpoch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !actual.Equals(expected) {
+			t.Fatalf("cached migration gave %s, full migration gave %s", actual, expected)
+		}
+		return report
+	}
+
+	if report := migrate(10); report.Hits != 0 {
+		t.Fatalf("expected no hits in an empty cache, got %d", report.Hits)
+	}
+	// Change an account and the balance of another, only the changed head
+	// misses.
+	set(testIDAddress(1003), builtin0.AccountActorCodeID, &account0.State{Address: testIDAddress(3003)}, big.NewInt(3))
+	set(testIDAddress(1004), builtin0.AccountActorCodeID, &account0.State{Address: testIDAddress(1004)}, big.NewInt(77))
+	if report := migrate(20); report.Hits != 21 {
+		t.Fatalf("expected 20 accounts and the multisig to hit, got %d hits", report.Hits)
+	}
+	if report := migrate(30); report.Hits != 22 {
+		t.Fatalf("expected 21 accounts and the multisig to hit, got %d hits", report.Hits)
+	}
+}
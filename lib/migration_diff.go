@@ -0,0 +1,135 @@
+package lib
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/filecoin-project/go-state-types/big"
+	builtin0 "github.com/filecoin-project/specs-actors/actors/builtin"
+	adt0 "github.com/filecoin-project/specs-actors/actors/util/adt"
+	builtin2 "github.com/filecoin-project/specs-actors/v2/actors/builtin"
+	miner2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/miner"
+	power2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/power"
+	reward2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/reward"
+	states2 "github.com/filecoin-project/specs-actors/v2/actors/states"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"golang.org/x/xerrors"
+)
+
+// migratedCodes maps every v0 actor code to the v2 code the migration gives
+// the actor.
+var migratedCodes = map[cid.Cid]cid.Cid{
+	builtin0.SystemActorCodeID:           builtin2.SystemActorCodeID,
+	builtin0.InitActorCodeID:             builtin2.InitActorCodeID,
+	builtin0.CronActorCodeID:             builtin2.CronActorCodeID,
+	builtin0.AccountActorCodeID:          builtin2.AccountActorCodeID,
+	builtin0.StoragePowerActorCodeID:     builtin2.StoragePowerActorCodeID,
+	builtin0.StorageMinerActorCodeID:     builtin2.StorageMinerActorCodeID,
+	builtin0.StorageMarketActorCodeID:    builtin2.StorageMarketActorCodeID,
+	builtin0.PaymentChannelActorCodeID:   builtin2.PaymentChannelActorCodeID,
+	builtin0.MultisigActorCodeID:         builtin2.MultisigActorCodeID,
+	builtin0.RewardActorCodeID:           builtin2.RewardActorCodeID,
+	builtin0.VerifiedRegistryActorCodeID: builtin2.VerifiedRegistryActorCodeID,
+}
+
+// migrationRenames maps fields of v2 objects to the fields of the v0 objects
+// they are migrated from, where the name changed.
+var migrationRenames = map[reflect.Type]map[string]string{
+	reflect.TypeOf(miner2.State{}):  {"InitialPledge": "InitialPledgeRequirement"},
+	reflect.TypeOf(reward2.State{}): {"TotalStoragePowerReward": "TotalMined"},
+}
+
+// migrationRecomputed holds the fields of v2 objects the migration sets
+// afresh rather than carrying over from v0.  Fields new in v2, such as the
+// miner FeeDebt, are skipped without being listed.
+var migrationRecomputed = map[reflect.Type]map[string]bool{
+	reflect.TypeOf(power2.State{}): {"ProofValidationBatch": true},
+	// The reward actor's baseline and reward filter are recomputed for the
+	// new baseline function.
+	reflect.TypeOf(reward2.State{}): {
+		"CumsumBaseline":          true,
+		"EffectiveNetworkTime":    true,
+		"EffectiveBaselinePower":  true,
+		"ThisEpochRewardSmoothed": true,
+		"ThisEpochBaselinePower":  true,
+	},
+}
+
+// DiffMigration compares the v0 actors tree at stateRootIn with the v2
+// actors tree at stateRootOut it was migrated to, and returns the actors
+// that differ beyond what the migration is expected to change, ordered by
+// address.  Changed actors carry their unexpected differences in Fields.
+//
+// Actors are expected to keep their nonce and to change their code to its v2
+// counterpart.  Balances are expected to be conserved after the repayment of
+// miner debt from burnt funds, see AuditMigration, and reported under the
+// path balance otherwise, as are unexpected code and nonce changes under
+// code and nonce.  States are diffed as with DiffActorStates, matching v0
+// fields to v2 fields of the same name and skipping the fields the migration
+// adds, renames or recomputes.  Differences left are semantic changes made
+// by the migration, such as the corrections of faulty miner states.
+func DiffMigration(ctx context.Context, store cbornode.IpldStore, stateRootIn, stateRootOut cid.Cid) ([]ActorStateDiff, error) {
+	if err := checkVersion(ctx, store, stateRootIn, ActorsVersion0); err != nil {
+		return nil, err
+	}
+	if err := checkVersion(ctx, store, stateRootOut, ActorsVersion2); err != nil {
+		return nil, err
+	}
+	_, debts, err := TreeMinerDebts(ctx, store, stateRootIn)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to load input miner debts: %w", err)
+	}
+	totalDebt := big.Zero()
+	for _, debt := range debts {
+		totalDebt = big.Add(totalDebt, debt)
+	}
+
+	adtStore := adt0.WrapStore(ctx, store)
+	treeIn, err := states2.LoadTree(adtStore, stateRootIn)
+	if err != nil {
+		return nil, err
+	}
+	treeOut, err := states2.LoadTree(adtStore, stateRootOut)
+	if err != nil {
+		return nil, err
+	}
+	actorDiffs, err := DiffStateTrees(treeIn, treeOut)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []ActorStateDiff
+	for _, ad := range actorDiffs {
+		if ad.Before == nil || ad.After == nil {
+			diffs = append(diffs, ActorStateDiff{ActorDiff: ad})
+			continue
+		}
+		d := &stateDiffer{ctx: ctx, store: store, migration: true}
+		if code := migratedCodes[ad.Before.Code]; !code.Equals(ad.After.Code) {
+			d.add("code", ad.Before.Code, ad.After.Code)
+		}
+		if ad.Before.CallSeqNum != ad.After.CallSeqNum {
+			d.add("nonce", ad.Before.CallSeqNum, ad.After.CallSeqNum)
+		}
+		expected := ad.Before.Balance
+		if debt, ok := debts[ad.Address]; ok {
+			expected = big.Add(expected, debt)
+		}
+		if ad.Address == builtin0.BurntFundsActorAddr {
+			expected = big.Sub(expected, totalDebt)
+		}
+		if !expected.Equals(ad.After.Balance) {
+			d.add("balance", ad.Before.Balance, ad.After.Balance)
+		}
+		if len(d.diffs) == 0 || d.diffs[0].Path != "code" {
+			if _, err := d.diffStates(ad.Before, ad.After); err != nil {
+				return nil, xerrors.Errorf("failed to diff state of %s: %w", ad.Address, err)
+			}
+		}
+		if len(d.diffs) > 0 {
+			diffs = append(diffs, ActorStateDiff{ActorDiff: ad, Fields: d.diffs})
+		}
+	}
+	return diffs, nil
+}
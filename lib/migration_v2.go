@@ -0,0 +1,47 @@
+package lib
+
+import (
+	"context"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	adt0 "github.com/filecoin-project/specs-actors/actors/util/adt"
+	builtin2 "github.com/filecoin-project/specs-actors/v2/actors/builtin"
+	migration2 "github.com/filecoin-project/specs-actors/v2/actors/migration"
+	states2 "github.com/filecoin-project/specs-actors/v2/actors/states"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+)
+
+// DefaultMigration is the migration run when none is named.
+const DefaultMigration = "v0-v2"
+
+func init() {
+	RegisterMigration(Migration{
+		Name:            DefaultMigration,
+		InputVersion:    ActorsVersion0,
+		OutputVersion:   ActorsVersion2,
+		Migrate:         migrateV0ToV2,
+		MigrateSerially: migrateV0ToV2Serially,
+		MigrateActor:    migrateActorV0ToV2,
+		ValidateInput:   ValidateV0,
+		Validate:        ValidateV2,
+	})
+}
+
+func migrateV0ToV2(ctx context.Context, store cbornode.IpldStore, stateRootIn cid.Cid, priorEpoch abi.ChainEpoch) (cid.Cid, error) {
+	return migration2.MigrateStateTree(ctx, store, stateRootIn, priorEpoch, migration2.DefaultConfig())
+}
+
+func migrateV0ToV2Serially(ctx context.Context, store cbornode.IpldStore, stateRootIn cid.Cid, priorEpoch abi.ChainEpoch) (cid.Cid, error) {
+	return migration2.MigrateStateTree(ctx, store, stateRootIn, priorEpoch, migration2.Config{MaxWorkers: 1})
+}
+
+// ValidateV2 checks all specs-actors v2 state invariants of the actors tree
+// at stateRoot against the network's expected total supply.
+func ValidateV2(ctx context.Context, store cbornode.IpldStore, stateRoot cid.Cid, priorEpoch abi.ChainEpoch, network Network) (*builtin2.MessageAccumulator, error) {
+	tree, err := states2.LoadTree(adt0.WrapStore(ctx, store), stateRoot)
+	if err != nil {
+		return nil, err
+	}
+	return states2.CheckStateInvariants(tree, network.ExpectedSupply(), priorEpoch)
+}
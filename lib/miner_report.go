@@ -0,0 +1,164 @@
+package lib
+
+import (
+	"context"
+
+	address "github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/dline"
+	miner2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/miner"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"golang.org/x/xerrors"
+)
+
+// MinerReport summarizes one storage miner's state.
+type MinerReport struct {
+	Address           address.Address
+	Version           ActorsVersion
+	Balance           abi.TokenAmount
+	Info              *MinerInfo
+	LockedFunds       abi.TokenAmount
+	InitialPledge     abi.TokenAmount
+	PreCommitDeposits abi.TokenAmount
+	FeeDebt           abi.TokenAmount
+	Available         abi.TokenAmount
+	PreCommits        uint64
+	// VestingEntries and VestingTotal count and sum the vesting table,
+	// which vests from VestingFirst to VestingLast.
+	VestingEntries int
+	VestingTotal   abi.TokenAmount
+	VestingFirst   abi.ChainEpoch
+	VestingLast    abi.ChainEpoch
+	Deadlines      []DeadlineReport
+	NextDeadline   *dline.Info
+}
+
+// DeadlineReport counts the sectors of each partition of a deadline.
+type DeadlineReport struct {
+	Index      uint64
+	Partitions []PartitionReport
+}
+
+// PartitionReport counts the sectors of a partition by state.  Live sectors
+// are those not terminated; faulty, recovering and unproven sectors are
+// live.  Recovering sectors are also faulty.
+type PartitionReport struct {
+	Index      uint64
+	Sectors    uint64
+	Live       uint64
+	Faulty     uint64
+	Recovering uint64
+	Terminated uint64
+	Unproven   uint64
+}
+
+// NewMinerReport summarizes the miner at the ID address addr in tree, of
+// any actors version.
+func NewMinerReport(ctx context.Context, store cbornode.IpldStore, tree *StateTree, addr address.Address) (*MinerReport, error) {
+	a, found, err := tree.GetActor(addr)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, xerrors.Errorf("actor %s not found in %s", addr, tree.Root)
+	}
+	if !IsMinerActor(a.Code) {
+		return nil, xerrors.Errorf("actor %s is not a miner", addr)
+	}
+	st, err := LoadMinerState(ctx, store, a)
+	if err != nil {
+		return nil, err
+	}
+	info, err := st.Info()
+	if err != nil {
+		return nil, xerrors.Errorf("failed to load miner info: %w", err)
+	}
+	report := &MinerReport{
+		Address:           addr,
+		Version:           tree.Version,
+		Balance:           a.Balance,
+		Info:              info,
+		LockedFunds:       st.LockedFunds(),
+		InitialPledge:     st.InitialPledge(),
+		PreCommitDeposits: st.PreCommitDeposits(),
+		FeeDebt:           st.FeeDebt(),
+		Available:         st.AvailableBalance(a.Balance),
+		VestingTotal:      big.Zero(),
+		NextDeadline:      st.NextDeadline(),
+	}
+	if report.PreCommits, err = st.PreCommitCount(); err != nil {
+		return nil, xerrors.Errorf("failed to count precommits: %w", err)
+	}
+	vesting, err := st.VestingFunds()
+	if err != nil {
+		return nil, xerrors.Errorf("failed to load vesting funds: %w", err)
+	}
+	report.VestingEntries = len(vesting)
+	for i, f := range vesting {
+		report.VestingTotal = big.Add(report.VestingTotal, f.Amount)
+		if i == 0 {
+			report.VestingFirst = f.Epoch
+		}
+		report.VestingLast = f.Epoch
+	}
+
+	// v0 and v2 miners have the same number of deadlines
+	report.Deadlines = make([]DeadlineReport, miner2.WPoStPeriodDeadlines)
+	for i := range report.Deadlines {
+		report.Deadlines[i].Index = uint64(i)
+	}
+	// The same walk ExportSectors does
+	if err := st.ForEachPartition(func(dlIdx, partIdx uint64, part *Partition) error {
+		pr, err := newPartitionReport(partIdx, part)
+		if err != nil {
+			return xerrors.Errorf("failed to count sectors of deadline %d partition %d: %w", dlIdx, partIdx, err)
+		}
+		report.Deadlines[dlIdx].Partitions = append(report.Deadlines[dlIdx].Partitions, *pr)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func newPartitionReport(idx uint64, part *Partition) (*PartitionReport, error) {
+	live, err := bitfield.SubtractBitField(part.Sectors, part.Terminated)
+	if err != nil {
+		return nil, err
+	}
+	pr := &PartitionReport{Index: idx}
+	for _, c := range []struct {
+		count *uint64
+		bf    bitfield.BitField
+	}{
+		{&pr.Sectors, part.Sectors},
+		{&pr.Live, live},
+		{&pr.Faulty, part.Faults},
+		{&pr.Recovering, part.Recoveries},
+		{&pr.Terminated, part.Terminated},
+		{&pr.Unproven, part.Unproven},
+	} {
+		if *c.count, err = c.bf.Count(); err != nil {
+			return nil, err
+		}
+	}
+	return pr, nil
+}
+
+// Totals sums the partition counts of all deadlines.
+func (r *MinerReport) Totals() PartitionReport {
+	var total PartitionReport
+	for _, dl := range r.Deadlines {
+		for _, p := range dl.Partitions {
+			total.Sectors += p.Sectors
+			total.Live += p.Live
+			total.Faulty += p.Faulty
+			total.Recovering += p.Recovering
+			total.Terminated += p.Terminated
+			total.Unproven += p.Unproven
+		}
+	}
+	return total
+}
@@ -0,0 +1,118 @@
+package lib
+
+import (
+	"context"
+	"strings"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/chain/actors/policy"
+	builtin2 "github.com/filecoin-project/specs-actors/v2/actors/builtin"
+	init2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/init"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"golang.org/x/xerrors"
+)
+
+// Network holds the parameters invariant checks need to know about the
+// network a state tree belongs to.
+type Network struct {
+	Name string
+	// GenesisNames are the init actor network names of the network's
+	// genesis.  Names ending in "*" match by prefix.
+	GenesisNames []string
+	// TotalFilecoin overrides the expected sum of all actor balances when
+	// set.  Every lotus network mints the same 2 billion FIL so known
+	// networks leave it unset, see ExpectedSupply.
+	TotalFilecoin abi.TokenAmount
+	// SupportedProofTypes, ConsensusMinerMinPower and MinVerifiedDealSize
+	// override the specs-actors mainnet policy when set.
+	SupportedProofTypes    []abi.RegisteredSealProof
+	ConsensusMinerMinPower abi.StoragePower
+	MinVerifiedDealSize    abi.StoragePower
+}
+
+var networks = []Network{
+	{
+		Name:         "mainnet",
+		GenesisNames: []string{"testnetnet"},
+	},
+	{
+		Name:         "calibnet",
+		GenesisNames: []string{"calibrationnet"},
+	},
+	{
+		// Local devnets built with the lotus 2k build tag
+		Name:                   "2k",
+		GenesisNames:           []string{"localnet-*"},
+		SupportedProofTypes:    []abi.RegisteredSealProof{abi.RegisteredSealProof_StackedDrg2KiBV1},
+		ConsensusMinerMinPower: abi.NewStoragePower(2048),
+		MinVerifiedDealSize:    abi.NewStoragePower(256),
+	},
+}
+
+// ExpectedSupply returns the expected sum of all actor balances of the
+// network, TotalFilecoin if set and the specs-actors total otherwise.
+func (n Network) ExpectedSupply() abi.TokenAmount {
+	if n.TotalFilecoin.Nil() {
+		return builtin2.TotalFilecoin
+	}
+	return n.TotalFilecoin
+}
+
+// GetNetwork returns the known network called name.
+func GetNetwork(name string) (Network, error) {
+	for _, n := range networks {
+		if n.Name == name {
+			return n, nil
+		}
+	}
+	return Network{}, xerrors.Errorf("unknown network %q, known networks: %v", name, NetworkNames())
+}
+
+// NetworkNames returns the names of all known networks.
+func NetworkNames() []string {
+	names := make([]string, 0, len(networks))
+	for _, n := range networks {
+		names = append(names, n.Name)
+	}
+	return names
+}
+
+// DetectNetwork returns the known network whose genesis name matches the
+// network name in the init actor of the actors tree at stateRoot.
+func DetectNetwork(ctx context.Context, store cbornode.IpldStore, stateRoot cid.Cid) (Network, error) {
+	initActor, err := LoadActor(ctx, store, stateRoot, builtin2.InitActorAddr)
+	if err != nil {
+		return Network{}, err
+	}
+	// v0 and v2 init actor states share an encoding
+	var st init2.State
+	if err := store.Get(ctx, initActor.Head, &st); err != nil {
+		return Network{}, xerrors.Errorf("failed to load init actor state: %w", err)
+	}
+	for _, n := range networks {
+		for _, genesisName := range n.GenesisNames {
+			if genesisName == st.NetworkName ||
+				strings.HasSuffix(genesisName, "*") && strings.HasPrefix(st.NetworkName, strings.TrimSuffix(genesisName, "*")) {
+				return n, nil
+			}
+		}
+	}
+	return Network{}, xerrors.Errorf("state %s belongs to unknown network %q, known networks: %v", stateRoot, st.NetworkName, NetworkNames())
+}
+
+// ApplyPolicy sets the network's policy overrides in specs-actors, across
+// all actors versions.  The policy is global so it also applies to
+// migrations run afterwards, apply it once before the first migration or
+// check of a state of the network.
+func (n Network) ApplyPolicy() {
+	if len(n.SupportedProofTypes) > 0 {
+		policy.SetSupportedProofTypes(n.SupportedProofTypes...)
+	}
+	if !n.ConsensusMinerMinPower.Nil() {
+		policy.SetConsensusMinerMinPower(n.ConsensusMinerMinPower)
+	}
+	if !n.MinVerifiedDealSize.Nil() {
+		policy.SetMinVerifiedDealSize(n.MinVerifiedDealSize)
+	}
+}
@@ -0,0 +1,170 @@
+package lib
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	address "github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	states2 "github.com/filecoin-project/specs-actors/v2/actors/states"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+)
+
+// slowestPerType is the number of slowest actors listed for each actor type.
+const slowestPerType = 5
+
+// ActorTiming is the time spent migrating a single actor.
+type ActorTiming struct {
+	Address  address.Address
+	Duration time.Duration
+}
+
+// ActorTypeProfile summarizes migration times of all actors of one type.
+type ActorTypeProfile struct {
+	Type    string
+	Count   int
+	Total   time.Duration
+	P50     time.Duration
+	P99     time.Duration
+	Max     time.Duration
+	Slowest []ActorTiming
+}
+
+// MigrationProfile breaks the duration of one migration down by actor type.
+type MigrationProfile struct {
+	StateRoot cid.Cid
+	Epoch     abi.ChainEpoch
+	Duration  time.Duration
+	Types     []ActorTypeProfile
+}
+
+type actorRef struct {
+	addr      address.Address
+	actorType string
+}
+
+// actorHead holds the actors sharing a head, next is the index of the next
+// one to start.
+type actorHead struct {
+	refs []actorRef
+	next int
+}
+
+type openActor struct {
+	ref   actorRef
+	start time.Time
+}
+
+// ProfilingStore wraps the store passed to a migration run on a single
+// worker and attributes time to the actor being migrated.  specs-actors does
+// not expose per-actor hooks so the store treats the first read of an input
+// actor's head as the start of that actor's migration, and the start of the
+// next actor, or the end of the migration, as its end.
+type ProfilingStore struct {
+	cbornode.IpldStore
+
+	lk      sync.Mutex
+	heads   map[cid.Cid]*actorHead
+	open    *openActor
+	timings map[string][]ActorTiming
+}
+
+// NewProfilingStore indexes the actor heads of the migration input at
+// stateRootIn and returns a store that profiles reads and writes to store.
+func NewProfilingStore(ctx context.Context, store cbornode.IpldStore, stateRootIn cid.Cid) (*ProfilingStore, error) {
+	heads := make(map[cid.Cid]*actorHead)
+	if err := ForEachActor(ctx, store, stateRootIn, func(addr address.Address, a *states2.Actor) error {
+		if heads[a.Head] == nil {
+			heads[a.Head] = &actorHead{}
+		}
+		heads[a.Head].refs = append(heads[a.Head].refs, actorRef{addr: addr, actorType: ActorTypeName(a.Code)})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return &ProfilingStore{
+		IpldStore: store,
+		heads:     heads,
+		timings:   make(map[string][]ActorTiming),
+	}, nil
+}
+
+func (ps *ProfilingStore) Get(ctx context.Context, c cid.Cid, out interface{}) error {
+	// Each actor is only started once, later reads of its head are attributed
+	// to whichever actor is being migrated.
+	if h, found := ps.heads[c]; found {
+		now := time.Now()
+		ps.lk.Lock()
+		if h.next < len(h.refs) {
+			ps.closeLocked(now)
+			ps.open = &openActor{ref: h.refs[h.next], start: now}
+			h.next++
+		}
+		ps.lk.Unlock()
+	}
+	return ps.IpldStore.Get(ctx, c, out)
+}
+
+func (ps *ProfilingStore) closeLocked(end time.Time) {
+	if ps.open == nil {
+		return
+	}
+	oa := ps.open
+	ps.open = nil
+	ps.timings[oa.ref.actorType] = append(ps.timings[oa.ref.actorType], ActorTiming{
+		Address:  oa.ref.addr,
+		Duration: end.Sub(oa.start),
+	})
+}
+
+// Profile summarizes the per-actor timings of the migration of stateRoot.
+// Call it after the migration has returned.
+func (ps *ProfilingStore) Profile(stateRoot cid.Cid, epoch abi.ChainEpoch, duration time.Duration) *MigrationProfile {
+	ps.Stop()
+	ps.lk.Lock()
+	defer ps.lk.Unlock()
+
+	profile := &MigrationProfile{
+		StateRoot: stateRoot,
+		Epoch:     epoch,
+		Duration:  duration,
+	}
+	for actorType, timings := range ps.timings {
+		sorted := make([]ActorTiming, len(timings))
+		copy(sorted, timings)
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i].Duration > sorted[j].Duration
+		})
+		tp := ActorTypeProfile{
+			Type:  actorType,
+			Count: len(sorted),
+			Max:   sorted[0].Duration,
+			P50:   sorted[len(sorted)/2].Duration,
+			P99:   sorted[len(sorted)/100].Duration,
+		}
+		for _, t := range sorted {
+			tp.Total += t.Duration
+		}
+		if len(sorted) > slowestPerType {
+			sorted = sorted[:slowestPerType]
+		}
+		tp.Slowest = sorted
+		profile.Types = append(profile.Types, tp)
+	}
+	sort.Slice(profile.Types, func(i, j int) bool {
+		return profile.Types[i].Total > profile.Types[j].Total
+	})
+	return profile
+}
+
+// Stop ends the actor still being timed.  Call it as soon as the migration
+// returns, Profile stops the store itself if it was not stopped.
+func (ps *ProfilingStore) Stop() {
+	end := time.Now()
+	ps.lk.Lock()
+	defer ps.lk.Unlock()
+	ps.closeLocked(end)
+}
@@ -0,0 +1,188 @@
+package lib
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	address "github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	adt0 "github.com/filecoin-project/specs-actors/actors/util/adt"
+	builtin2 "github.com/filecoin-project/specs-actors/v2/actors/builtin"
+	miner2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/miner"
+	power2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/power"
+	states2 "github.com/filecoin-project/specs-actors/v2/actors/states"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"golang.org/x/xerrors"
+)
+
+// CheckTypeNames returns the actor type names that can be selected.
+func CheckTypeNames() []string {
+	known := make(map[string]bool)
+	var names []string
+	for _, name := range actorTypes {
+		if !known[name] {
+			known[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CheckSelection chooses the actors whose invariant checks are run.  The
+// zero value selects every actor.
+type CheckSelection struct {
+	Types     map[string]bool
+	Addresses map[address.Address]bool
+}
+
+// NewCheckSelection selects all actors of the named types and the actors at
+// addrs.  No types and no addresses select every actor.
+func NewCheckSelection(types []string, addrs []address.Address) (CheckSelection, error) {
+	known := make(map[string]bool, len(actorTypes))
+	for _, name := range actorTypes {
+		known[name] = true
+	}
+	sel := CheckSelection{}
+	for _, t := range types {
+		if !known[t] {
+			return CheckSelection{}, xerrors.Errorf("unknown actor type %q, known types: %v", t, CheckTypeNames())
+		}
+		if sel.Types == nil {
+			sel.Types = make(map[string]bool)
+		}
+		sel.Types[t] = true
+	}
+	for _, addr := range addrs {
+		if sel.Addresses == nil {
+			sel.Addresses = make(map[address.Address]bool)
+		}
+		sel.Addresses[addr] = true
+	}
+	return sel, nil
+}
+
+// All returns true if the selection selects every actor.
+func (s CheckSelection) All() bool {
+	return len(s.Types) == 0 && len(s.Addresses) == 0
+}
+
+func (s CheckSelection) selects(addr address.Address, actorType string) bool {
+	return s.All() || s.Types[actorType] || s.Addresses[addr]
+}
+
+// ActorTypeChecks counts the actors of one type whose checks were run or
+// skipped and the time spent running them.
+type ActorTypeChecks struct {
+	Type     string
+	Checked  int
+	Skipped  int
+	Duration time.Duration
+}
+
+// CheckStateInvariantsV2 runs the specs-actors v2 invariant checks of the
+// actors selected by sel in the actors tree at stateRoot.  With every actor
+// selected it checks the same invariants as states2.CheckStateInvariants.
+// Checks of the whole tree, such as the total balance, always run.  The
+// cross-actor checks between miners and power run for every selected miner,
+// computing the power actor summary even if power is not selected; selecting
+// only power skips them as they would need every miner checked.
+func CheckStateInvariantsV2(ctx context.Context, store cbornode.IpldStore, stateRoot cid.Cid, priorEpoch abi.ChainEpoch, network Network, sel CheckSelection) (*builtin2.MessageAccumulator, []ActorTypeChecks, error) {
+	acc, checks, _, err := checkStateInvariantsV2(ctx, store, stateRoot, priorEpoch, network, sel)
+	return acc, checks, err
+}
+
+// checkStateInvariantsV2 also returns the state summaries of the checked
+// actors by address.
+func checkStateInvariantsV2(ctx context.Context, store cbornode.IpldStore, stateRoot cid.Cid, priorEpoch abi.ChainEpoch, network Network, sel CheckSelection) (*builtin2.MessageAccumulator, []ActorTypeChecks, map[address.Address]interface{}, error) {
+	adtStore := adt0.WrapStore(ctx, store)
+	tree, err := states2.LoadTree(adtStore, stateRoot)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	acc := &builtin2.MessageAccumulator{}
+	stats := make(map[string]*ActorTypeChecks)
+	totalFIL := big.Zero()
+	var powerActor *states2.Actor
+	var powerSummary *power2.StateSummary
+	minerSummaries := make(map[address.Address]*miner2.StateSummary)
+	summaries := make(map[address.Address]interface{})
+
+	if err := tree.ForEach(func(addr address.Address, a *states2.Actor) error {
+		actorAcc := acc.WithPrefix("%v ", addr)
+		if addr.Protocol() != address.ID {
+			actorAcc.Addf("unexpected address protocol in state tree root: %v", addr)
+		}
+		totalFIL = big.Add(totalFIL, a.Balance)
+
+		actorType, known := actorTypes[a.Code]
+		if !known {
+			return xerrors.Errorf("unexpected actor code CID %v for address %v", a.Code, addr)
+		}
+		st, ok := stats[actorType]
+		if !ok {
+			st = &ActorTypeChecks{Type: actorType}
+			stats[actorType] = st
+		}
+		if a.Code == builtin2.StoragePowerActorCodeID {
+			actorCopy := *a
+			powerActor = &actorCopy
+		}
+		if !sel.selects(addr, actorType) {
+			st.Skipped++
+			return nil
+		}
+
+		start := time.Now()
+		summary, msgs, err := checkActorInvariants(adtStore, addr, a, priorEpoch)
+		st.Duration += time.Since(start)
+		st.Checked++
+		if err != nil {
+			return err
+		}
+		actorAcc.WithPrefix("%s: ", actorType).AddAll(msgs)
+		if summary != nil {
+			summaries[addr] = summary
+		}
+		switch s := summary.(type) {
+		case *miner2.StateSummary:
+			minerSummaries[addr] = s
+		case *power2.StateSummary:
+			powerSummary = s
+		}
+		return nil
+	}); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Selected miners are checked against power even if power itself was
+	// not selected, its messages are dropped then
+	if len(minerSummaries) > 0 && powerSummary == nil && powerActor != nil {
+		start := time.Now()
+		summary, _, err := checkActorInvariants(adtStore, builtin2.StoragePowerActorAddr, powerActor, priorEpoch)
+		stats["power"].Duration += time.Since(start)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		powerSummary = summary.(*power2.StateSummary)
+	}
+	if len(minerSummaries) > 0 && powerSummary != nil {
+		states2.CheckMinersAgainstPower(acc, minerSummaries, powerSummary)
+	}
+
+	if !totalFIL.Equals(network.ExpectedSupply()) {
+		acc.Addf("total token balance is %v, expected %v", totalFIL, network.ExpectedSupply())
+	}
+
+	checks := make([]ActorTypeChecks, 0, len(stats))
+	for _, st := range stats {
+		checks = append(checks, *st)
+	}
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].Type < checks[j].Type
+	})
+	return acc, checks, summaries, nil
+}
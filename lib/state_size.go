@@ -0,0 +1,287 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"sort"
+
+	address "github.com/filecoin-project/go-address"
+	hamt "github.com/filecoin-project/go-hamt-ipld/v2"
+	states2 "github.com/filecoin-project/specs-actors/v2/actors/states"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+)
+
+// headField is the field blocks are attributed to when they are an actor's
+// head or reached from it other than through a state field.
+const headField = "(head)"
+
+// SizeCount counts unique blocks and their bytes.
+type SizeCount struct {
+	Blocks uint64
+	Bytes  uint64
+}
+
+func (s *SizeCount) add(size int) {
+	s.Blocks++
+	s.Bytes += uint64(size)
+}
+
+// ActorTypeSize is the storage of all actors of a type.
+type ActorTypeSize struct {
+	Type   string
+	Actors int
+	SizeCount
+}
+
+// ActorSize is the storage of one actor.
+type ActorSize struct {
+	Address address.Address
+	Type    string
+	SizeCount
+}
+
+// FieldSize is the storage of one state field of all actors of a type.
+type FieldSize struct {
+	Type  string
+	Field string
+	SizeCount
+}
+
+// StateSizeReport attributes the blocks of a state tree to the actors and
+// state fields they are reached from.
+type StateSizeReport struct {
+	Root  cid.Cid
+	Total SizeCount
+	// Tree counts the blocks of the state root and the actors HAMT.
+	Tree SizeCount
+	// SharedRefs counts links to blocks already attributed to an actor or
+	// field, which are not counted again.
+	SharedRefs uint64
+	// Types, Actors and Fields are ordered by bytes, largest first.  Actors
+	// holds the largest actors only.
+	Types  []ActorTypeSize
+	Actors []ActorSize
+	Fields []FieldSize
+}
+
+// StateSize walks every block reachable from the state root of tree and
+// attributes it to the actor type, actor and top level state field it is
+// first reached from, counting blocks shared by actors or fields once.
+// Blocks a state links to outside of its fields, and the head itself, are
+// attributed to the field (head).  The topN largest actors are reported.
+func StateSize(ctx context.Context, store cbornode.IpldStore, tree *StateTree, topN int) (*StateSizeReport, error) {
+	report := &StateSizeReport{Root: tree.Root}
+	types := make(map[string]*ActorTypeSize)
+	fields := make(map[[2]string]*SizeCount)
+	var actors []ActorSize
+	w, err := walkStateTree(ctx, store, tree, &report.Tree, func(addr address.Address, actorType string, actorFields map[string]*SizeCount) {
+		actor := ActorSize{Address: addr, Type: actorType}
+		for field, size := range actorFields {
+			actor.Blocks += size.Blocks
+			actor.Bytes += size.Bytes
+			key := [2]string{actorType, field}
+			if fields[key] == nil {
+				fields[key] = &SizeCount{}
+			}
+			fields[key].Blocks += size.Blocks
+			fields[key].Bytes += size.Bytes
+		}
+		if types[actorType] == nil {
+			types[actorType] = &ActorTypeSize{Type: actorType}
+		}
+		types[actorType].Actors++
+		types[actorType].Blocks += actor.Blocks
+		types[actorType].Bytes += actor.Bytes
+		actors = append(actors, actor)
+	})
+	if err != nil {
+		return nil, err
+	}
+	report.Total = report.Tree
+	report.SharedRefs = w.shared
+
+	for _, t := range types {
+		report.Types = append(report.Types, *t)
+		report.Total.Blocks += t.Blocks
+		report.Total.Bytes += t.Bytes
+	}
+	sort.Slice(report.Types, func(i, j int) bool { return report.Types[i].Bytes > report.Types[j].Bytes })
+	for key, size := range fields {
+		report.Fields = append(report.Fields, FieldSize{Type: key[0], Field: key[1], SizeCount: *size})
+	}
+	sort.Slice(report.Fields, func(i, j int) bool { return report.Fields[i].Bytes > report.Fields[j].Bytes })
+	sort.Slice(actors, func(i, j int) bool { return actors[i].Bytes > actors[j].Bytes })
+	if len(actors) > topN {
+		actors = actors[:topN]
+	}
+	report.Actors = actors
+	return report, nil
+}
+
+// treeOwner is the owner of the blocks of the state root and actors HAMT.
+const treeOwner = "state tree"
+
+// walkStateTree walks every block reachable from the state root of tree,
+// counting the blocks of the state root and actors HAMT in treeSize and
+// calling cb with the blocks first reached from each actor, by field.  The
+// returned walker holds every block walked with its owner.
+func walkStateTree(ctx context.Context, store cbornode.IpldStore, tree *StateTree, treeSize *SizeCount, cb func(addr address.Address, actorType string, fields map[string]*SizeCount)) (*sizeWalker, error) {
+	w := &sizeWalker{ctx: ctx, store: store, owner: treeOwner, blocks: make(map[cid.Cid]StateBlock)}
+	if tree.Versioned {
+		// The state root and its info, but not the actors HAMT walked below
+		w.blocks[tree.Actors] = StateBlock{}
+		if err := w.walk(tree.Root, treeSize); err != nil {
+			return nil, err
+		}
+		delete(w.blocks, tree.Actors)
+	}
+
+	var walkActors func(c cid.Cid) error
+	walkActors = func(c cid.Cid) error {
+		w.owner = treeOwner
+		raw, first, err := w.visit(c)
+		if err != nil || !first {
+			return err
+		}
+		treeSize.add(len(raw))
+		var node hamt.Node
+		if err := node.UnmarshalCBOR(bytes.NewReader(raw)); err != nil {
+			return err
+		}
+		for _, p := range node.Pointers {
+			if p.Link.Defined() {
+				if err := walkActors(p.Link); err != nil {
+					return err
+				}
+				continue
+			}
+			for _, kv := range p.KVs {
+				addr, err := address.NewFromBytes(kv.Key)
+				if err != nil {
+					return err
+				}
+				var a states2.Actor
+				if err := a.UnmarshalCBOR(bytes.NewReader(kv.Value.Raw)); err != nil {
+					return err
+				}
+				actorType := ActorTypeName(a.Code)
+				w.owner = actorType
+				fields, err := w.walkActor(&a)
+				if err != nil {
+					return xerrors.Errorf("failed to walk state of %s: %w", addr, err)
+				}
+				cb(addr, actorType, fields)
+			}
+		}
+		return nil
+	}
+	if err := walkActors(tree.Actors); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// sizeWalker counts the blocks reachable from roots, each once, recording
+// each block with the owner it is walked for.
+type sizeWalker struct {
+	ctx    context.Context
+	store  cbornode.IpldStore
+	owner  string
+	blocks map[cid.Cid]StateBlock
+	shared uint64
+}
+
+// walkActor counts the blocks of a's state not yet counted by field.
+func (w *sizeWalker) walkActor(a *states2.Actor) (map[string]*SizeCount, error) {
+	sizes := map[string]*SizeCount{headField: {}}
+	// The field of each link held in the head, where the state is known
+	fieldOf := make(map[cid.Cid]string)
+	if newState, found := actorStates[a.Code]; found {
+		st := newState()
+		if err := w.store.Get(w.ctx, a.Head, st); err != nil {
+			return nil, err
+		}
+		v := reflect.ValueOf(st).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			name := v.Type().Field(i).Name
+			f := v.Field(i)
+			if root, ok := linkRoot(f); ok {
+				fieldOf[root] = name
+			} else if f.Kind() == reflect.Array && f.Type().Elem() == reflect.TypeOf(cid.Cid{}) {
+				for j := 0; j < f.Len(); j++ {
+					fieldOf[f.Index(j).Interface().(cid.Cid)] = name
+				}
+			}
+		}
+	}
+
+	raw, first, err := w.visit(a.Head)
+	if err != nil || !first {
+		return sizes, err
+	}
+	sizes[headField].add(len(raw))
+	var links []cid.Cid
+	if err := cbg.ScanForLinks(bytes.NewReader(raw), func(c cid.Cid) {
+		links = append(links, c)
+	}); err != nil {
+		return nil, err
+	}
+	for _, c := range links {
+		field, found := fieldOf[c]
+		if !found {
+			field = headField
+		}
+		if sizes[field] == nil {
+			sizes[field] = &SizeCount{}
+		}
+		if err := w.walk(c, sizes[field]); err != nil {
+			return nil, err
+		}
+	}
+	return sizes, nil
+}
+
+// visit loads the block at c if it is a DAG-CBOR block not yet counted.
+func (w *sizeWalker) visit(c cid.Cid) ([]byte, bool, error) {
+	if c.Prefix().Codec != cid.DagCBOR {
+		// Identity actor codes and piece and sector commitments
+		return nil, false, nil
+	}
+	if _, found := w.blocks[c]; found {
+		w.shared++
+		return nil, false, nil
+	}
+	raw, err := loadRaw(w.ctx, w.store, c)
+	if err != nil {
+		return nil, false, xerrors.Errorf("failed to load block %s: %w", c, err)
+	}
+	w.blocks[c] = StateBlock{Owner: w.owner, Size: len(raw)}
+	return raw, true, nil
+}
+
+// walk counts in size the blocks reachable from root not yet counted.
+func (w *sizeWalker) walk(root cid.Cid, size *SizeCount) error {
+	stack := []cid.Cid{root}
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		raw, first, err := w.visit(c)
+		if err != nil {
+			return err
+		}
+		if !first {
+			continue
+		}
+		size.add(len(raw))
+		if err := cbg.ScanForLinks(bytes.NewReader(raw), func(link cid.Cid) {
+			stack = append(stack, link)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package lib
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	migration2 "github.com/filecoin-project/specs-actors/v2/actors/migration"
+)
+
+// TestStateSizeTypesAcrossVersions checks that actor types are named alike
+// in v0 and v2 states, so sizes compare across the upgrade.
+func TestStateSizeTypesAcrossVersions(t *testing.T) {
+	ctx := context.Background()
+	store := newMemCborStore()
+	tree, _ := testV0Tree(t, ctx, store)
+	rootV0, err := tree.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootV2, err := migration2.MigrateStateTree(ctx, store, rootV0, 10, migration2.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	typeNames := func(st *StateTree) []string {
+		report, err := StateSize(ctx, store, st, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, ts := range report.Types {
+			names = append(names, ts.Type)
+		}
+		sort.Strings(names)
+		return names
+	}
+	stV0, err := LoadStateTree(ctx, store, rootV0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stV2, err := LoadStateTree(ctx, store, rootV2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"account", "cron", "init", "market", "power", "reward", "system", "verifreg"}
+	if names := typeNames(stV0); !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected v0 actor types %v, got %v", expected, names)
+	}
+	if names := typeNames(stV2); !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected v2 actor types %v, got %v", expected, names)
+	}
+}
@@ -0,0 +1,112 @@
+package lib
+
+import (
+	"context"
+
+	address "github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/lotus/chain/types"
+	adt0 "github.com/filecoin-project/specs-actors/actors/util/adt"
+	builtin2 "github.com/filecoin-project/specs-actors/v2/actors/builtin"
+	init2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/init"
+	states2 "github.com/filecoin-project/specs-actors/v2/actors/states"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"golang.org/x/xerrors"
+)
+
+// StateTree is an actors tree tagged with its actors version.  The embedded
+// tree reads actors of either version as v0 and v2 share the actors HAMT
+// encoding, but actor states must be decoded with the types of Version.
+type StateTree struct {
+	*states2.Tree
+	// Root is the root the tree was loaded from, either a versioned
+	// types.StateRoot or a legacy bare actors HAMT root.
+	Root cid.Cid
+	// Actors is the root of the actors HAMT.
+	Actors cid.Cid
+	// Versioned is true if Root is a types.StateRoot.
+	Versioned bool
+	// StateTreeVersion is the version of the types.StateRoot wrapper, zero
+	// for bare roots.
+	StateTreeVersion types.StateTreeVersion
+	// Version is the actors version detected from the actors' code CIDs.
+	Version ActorsVersion
+}
+
+// LoadStateTree loads the state tree at root, which may be a versioned
+// types.StateRoot, as used from actors v2, or a legacy bare actors HAMT root.
+func LoadStateTree(ctx context.Context, store cbornode.IpldStore, root cid.Cid) (*StateTree, error) {
+	st := &StateTree{Root: root, Actors: root}
+	// A StateRoot is a three element array, a HAMT node a two element one,
+	// so a bare root fails to decode as a StateRoot.  Any other error, such as
+	// a missing block, is returned.
+	var stateRoot types.StateRoot
+	err := store.Get(ctx, root, &stateRoot)
+	var decodeErr cbornode.SerializationError
+	switch {
+	case err == nil:
+		st.Versioned = true
+		st.StateTreeVersion = stateRoot.Version
+		st.Actors = stateRoot.Actors
+	case !xerrors.As(err, &decodeErr):
+		return nil, xerrors.Errorf("failed to load state root %s: %w", root, err)
+	}
+	version, err := DetectActorsVersion(ctx, store, st.Actors)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to load state tree %s: %w", root, err)
+	}
+	st.Version = version
+	tree, err := states2.LoadTree(adt0.WrapStore(ctx, store), st.Actors)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to load actors tree %s: %w", st.Actors, err)
+	}
+	st.Tree = tree
+	return st, nil
+}
+
+// RequireVersion returns an error if the tree is not of the given actors
+// version.
+func (st *StateTree) RequireVersion(version ActorsVersion) error {
+	if st.Version != version {
+		return xerrors.Errorf("state %s has actors version %s, expected %s", st.Root, st.Version, version)
+	}
+	return nil
+}
+
+// ResolveAddress returns the ID address of addr, looking up non-ID addresses
+// in the init actor.
+func (st *StateTree) ResolveAddress(ctx context.Context, store cbornode.IpldStore, addr address.Address) (address.Address, error) {
+	if addr.Protocol() == address.ID {
+		return addr, nil
+	}
+	initActor, found, err := st.GetActor(builtin2.InitActorAddr)
+	if err != nil {
+		return address.Undef, err
+	}
+	if !found {
+		return address.Undef, xerrors.Errorf("init actor not found in %s", st.Root)
+	}
+	// v0 and v2 init actor states share an encoding
+	var initState init2.State
+	if err := store.Get(ctx, initActor.Head, &initState); err != nil {
+		return address.Undef, xerrors.Errorf("failed to load init actor state: %w", err)
+	}
+	idAddr, found, err := initState.ResolveAddress(adt0.WrapStore(ctx, store), addr)
+	if err != nil {
+		return address.Undef, err
+	}
+	if !found {
+		return address.Undef, xerrors.Errorf("address %s not found in %s", addr, st.Root)
+	}
+	return idAddr, nil
+}
+
+// LoadActorsRoot returns the actors HAMT root of the state at root, which may
+// be a versioned types.StateRoot or a legacy bare actors HAMT root.
+func LoadActorsRoot(ctx context.Context, store cbornode.IpldStore, root cid.Cid) (cid.Cid, error) {
+	st, err := LoadStateTree(ctx, store, root)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return st.Actors, nil
+}
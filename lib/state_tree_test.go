@@ -0,0 +1,46 @@
+package lib
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	cid "github.com/ipfs/go-cid"
+)
+
+func TestLoadStateTreeRoots(t *testing.T) {
+	ctx := context.Background()
+	store := newMemCborStore()
+	tree, _ := testV0Tree(t, ctx, store)
+	actorsRoot, err := tree.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := LoadStateTree(ctx, store, actorsRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Versioned || !st.Actors.Equals(actorsRoot) || st.Version != ActorsVersion0 {
+		t.Fatalf("expected bare v0 root %s, got versioned %t actors %s version %s", actorsRoot, st.Versioned, st.Actors, st.Version)
+	}
+
+	stateRoot, err := store.Put(ctx, &types.StateRoot{Version: types.StateTreeVersion1, Actors: actorsRoot, Info: actorsRoot})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st, err = LoadStateTree(ctx, store, stateRoot); err != nil {
+		t.Fatal(err)
+	}
+	if !st.Versioned || !st.Actors.Equals(actorsRoot) || st.StateTreeVersion != types.StateTreeVersion1 {
+		t.Fatalf("expected versioned root of %s, got versioned %t actors %s", actorsRoot, st.Versioned, st.Actors)
+	}
+
+	// A missing root is not mistaken for a bare actors root
+	missing, err := cid.Decode("bafy2bzacecnamqgqmifpluoeldx7zzglxcljo6oja4vrmtj7432rphldpdmm2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadStateTree(ctx, store, missing); err == nil {
+		t.Fatal("expected an error loading a missing root")
+	}
+}
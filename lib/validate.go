@@ -0,0 +1,73 @@
+package lib
+
+import (
+	"context"
+	"time"
+
+	address "github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	builtin2 "github.com/filecoin-project/specs-actors/v2/actors/builtin"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"golang.org/x/xerrors"
+)
+
+// ValidateOptions configures Validate.
+type ValidateOptions struct {
+	// Network the state belongs to, detected from the state's init actor if
+	// nil.  Validate does not apply the network's policy, callers checking
+	// states of networks with policy overrides call Network.ApplyPolicy
+	// first.
+	Network *Network
+	// Selection chooses the actors whose checks run on v2 states.  The zero
+	// value checks every actor.  v0 states are always checked in full.
+	Selection CheckSelection
+	// Baseline holds accepted violations, matching violations of the result
+	// are marked accepted.
+	Baseline []Violation
+}
+
+// Validate checks the invariants of the state at stateRoot, created at
+// priorEpoch, with the checks of its detected actors version.  stateRoot may
+// be a versioned types.StateRoot or a bare actors HAMT root.
+func Validate(ctx context.Context, store cbornode.IpldStore, stateRoot cid.Cid, priorEpoch abi.ChainEpoch, opts ValidateOptions) (*ValidationResult, error) {
+	tree, err := LoadStateTree(ctx, store, stateRoot)
+	if err != nil {
+		return nil, err
+	}
+	actorsRoot, version := tree.Actors, tree.Version
+	var network Network
+	if opts.Network != nil {
+		network = *opts.Network
+	} else {
+		network, err = DetectNetwork(ctx, store, actorsRoot)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var acc *builtin2.MessageAccumulator
+	var checks []ActorTypeChecks
+	var summaries map[address.Address]interface{}
+	start := time.Now()
+	switch version {
+	case ActorsVersion0:
+		acc, err = ValidateV0(ctx, store, actorsRoot, priorEpoch, network)
+	case ActorsVersion2:
+		acc, checks, summaries, err = checkStateInvariantsV2(ctx, store, actorsRoot, priorEpoch, network, opts.Selection)
+	default:
+		return nil, xerrors.Errorf("no invariant checks for actors version %s", version)
+	}
+	duration := time.Since(start)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to check state invariants: %w", err)
+	}
+
+	result := NewValidationResult(stateRoot, priorEpoch, duration, acc)
+	result.AcceptBaseline(opts.Baseline)
+	result.Version = version
+	result.Network = network.Name
+	result.Checks = checks
+	result.Summaries = summaries
+	return result, nil
+}
@@ -0,0 +1,159 @@
+package lib
+
+import (
+	"context"
+
+	address "github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	builtin0 "github.com/filecoin-project/specs-actors/actors/builtin"
+	init0 "github.com/filecoin-project/specs-actors/actors/builtin/init"
+	market0 "github.com/filecoin-project/specs-actors/actors/builtin/market"
+	miner0 "github.com/filecoin-project/specs-actors/actors/builtin/miner"
+	power0 "github.com/filecoin-project/specs-actors/actors/builtin/power"
+	adt0 "github.com/filecoin-project/specs-actors/actors/util/adt"
+	builtin2 "github.com/filecoin-project/specs-actors/v2/actors/builtin"
+	states2 "github.com/filecoin-project/specs-actors/v2/actors/states"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"golang.org/x/xerrors"
+)
+
+// ValidateV0 checks invariants of the specs-actors v0 actors tree at
+// stateRoot.  specs-actors v0 ships no state invariant checks so this is a
+// smaller set written here: balances sum to the network's total supply, actors are
+// keyed by ID addresses below the init actor's next ID, miners hold their
+// locked funds, power claims belong to miners and market locked balances are
+// covered by escrow.  Messages are prefixed like those of ValidateV2.
+func ValidateV0(ctx context.Context, store cbornode.IpldStore, stateRoot cid.Cid, priorEpoch abi.ChainEpoch, network Network) (*builtin2.MessageAccumulator, error) {
+	adtStore := adt0.WrapStore(ctx, store)
+	acc := &builtin2.MessageAccumulator{}
+
+	initActor, err := LoadActor(ctx, store, stateRoot, builtin0.InitActorAddr)
+	if err != nil {
+		return nil, err
+	}
+	var initSt init0.State
+	if err := adtStore.Get(ctx, initActor.Head, &initSt); err != nil {
+		return nil, xerrors.Errorf("failed to load init actor state: %w", err)
+	}
+
+	totalBalance := big.Zero()
+	miners := make(map[address.Address]struct{})
+	var powerActor, marketActor *states2.Actor
+	if err := ForEachActor(ctx, store, stateRoot, func(addr address.Address, a *states2.Actor) error {
+		totalBalance = big.Add(totalBalance, a.Balance)
+		actorAcc := acc.WithPrefix("%v ", addr)
+		actorAcc.Require(addr.Protocol() == address.ID, "address must be an ID address")
+		if addr.Protocol() == address.ID {
+			id, err := address.IDFromAddress(addr)
+			if err != nil {
+				return err
+			}
+			actorAcc.Require(abi.ActorID(id) < initSt.NextID, "ID is at or above init actor next ID %d", initSt.NextID)
+		}
+		actorAcc.Require(builtin0.IsBuiltinActor(a.Code), "unknown code %v", a.Code)
+
+		switch a.Code {
+		case builtin0.StorageMinerActorCodeID:
+			miners[addr] = struct{}{}
+			return checkMinerV0(adtStore, actorAcc.WithPrefix("miner: "), a)
+		case builtin0.StoragePowerActorCodeID:
+			powerActor = a
+		case builtin0.StorageMarketActorCodeID:
+			marketActor = a
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	acc.Require(totalBalance.Equals(network.ExpectedSupply()), "total token balance is %v, expected %v", totalBalance, network.ExpectedSupply())
+
+	if powerActor == nil {
+		acc.Addf("power actor not found")
+	} else if err := checkPowerV0(adtStore, acc.WithPrefix("%v power: ", builtin0.StoragePowerActorAddr), powerActor, miners); err != nil {
+		return nil, err
+	}
+	if marketActor == nil {
+		acc.Addf("market actor not found")
+	} else if err := checkMarketV0(adtStore, acc.WithPrefix("%v market: ", builtin0.StorageMarketActorAddr), marketActor); err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+func checkMinerV0(store adt0.Store, acc *builtin2.MessageAccumulator, a *states2.Actor) error {
+	var st miner0.State
+	if err := store.Get(store.Context(), a.Head, &st); err != nil {
+		return xerrors.Errorf("failed to load miner state: %w", err)
+	}
+	if _, err := st.GetInfo(store); err != nil {
+		acc.Addf("failed to load miner info: %v", err)
+	}
+	if _, err := st.LoadDeadlines(store); err != nil {
+		acc.Addf("failed to load deadlines: %v", err)
+	}
+	acc.Require(st.LockedFunds.GreaterThanEqual(big.Zero()), "locked funds %v is negative", st.LockedFunds)
+	acc.Require(st.PreCommitDeposits.GreaterThanEqual(big.Zero()), "precommit deposits %v is negative", st.PreCommitDeposits)
+	held := big.Add(st.LockedFunds, st.PreCommitDeposits)
+	acc.Require(a.Balance.GreaterThanEqual(held), "balance %v is less than locked funds and precommit deposits %v", a.Balance, held)
+	return nil
+}
+
+func checkPowerV0(store adt0.Store, acc *builtin2.MessageAccumulator, a *states2.Actor, miners map[address.Address]struct{}) error {
+	var st power0.State
+	if err := store.Get(store.Context(), a.Head, &st); err != nil {
+		return xerrors.Errorf("failed to load power state: %w", err)
+	}
+	claims, err := adt0.AsMap(store, st.Claims)
+	if err != nil {
+		return err
+	}
+	var claim power0.Claim
+	claimCount := int64(0)
+	if err := claims.ForEach(&claim, func(key string) error {
+		addr, err := address.NewFromBytes([]byte(key))
+		if err != nil {
+			return err
+		}
+		claimCount++
+		_, found := miners[addr]
+		acc.Require(found, "claim for %v has no miner actor", addr)
+		acc.Require(claim.RawBytePower.GreaterThanEqual(big.Zero()), "claim for %v has negative raw byte power", addr)
+		acc.Require(claim.QualityAdjPower.GreaterThanEqual(big.Zero()), "claim for %v has negative quality adjusted power", addr)
+		return nil
+	}); err != nil {
+		return err
+	}
+	acc.Require(claimCount == st.MinerCount, "miner count %d does not match %d claims", st.MinerCount, claimCount)
+	return nil
+}
+
+func checkMarketV0(store adt0.Store, acc *builtin2.MessageAccumulator, a *states2.Actor) error {
+	var st market0.State
+	if err := store.Get(store.Context(), a.Head, &st); err != nil {
+		return xerrors.Errorf("failed to load market state: %w", err)
+	}
+	escrow, err := adt0.AsBalanceTable(store, st.EscrowTable)
+	if err != nil {
+		return err
+	}
+	locked, err := adt0.AsMap(store, st.LockedTable)
+	if err != nil {
+		return err
+	}
+	var amount abi.TokenAmount
+	return locked.ForEach(&amount, func(key string) error {
+		addr, err := address.NewFromBytes([]byte(key))
+		if err != nil {
+			return err
+		}
+		escrowed, err := escrow.Get(addr)
+		if err != nil {
+			return err
+		}
+		acc.Require(amount.GreaterThanEqual(big.Zero()), "locked balance of %v is negative", addr)
+		acc.Require(escrowed.GreaterThanEqual(amount), "locked balance %v of %v exceeds escrow %v", amount, addr, escrowed)
+		return nil
+	})
+}
@@ -0,0 +1,217 @@
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	address "github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	builtin2 "github.com/filecoin-project/specs-actors/v2/actors/builtin"
+	cid "github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+)
+
+// Violation categories for messages not reported by a single actor's checks.
+const (
+	// CategoryTree is the category of checks over the whole tree, such as
+	// the total balance.
+	CategoryTree = "tree"
+	// CategoryActor is the category of checks of an actor not specific to
+	// its type, such as its address protocol.
+	CategoryActor = "actor"
+	// CategoryMinerPower is the category of checks comparing miner state
+	// with power and cron actor state.
+	CategoryMinerPower = "miner-power"
+)
+
+// Violation is a single message reported by invariant checks.
+type Violation struct {
+	// Address is the actor the message was reported for, empty for
+	// violations of the whole tree.
+	Address string
+	// Category is the actor type whose checks reported the message, or one
+	// of the Category constants.
+	Category string
+	Message  string
+	// Accepted is set on violations matched by the baseline the result was
+	// checked against, see AcceptBaseline.
+	Accepted bool `json:",omitempty"`
+}
+
+// ValidationResult is the outcome of checking the invariants of one state.
+type ValidationResult struct {
+	StateRoot  cid.Cid
+	Epoch      abi.ChainEpoch
+	Version    ActorsVersion
+	Network    string `json:",omitempty"`
+	Duration   time.Duration
+	Violations []Violation
+	// Checks counts the actors checked and skipped by type, for validations
+	// that record them.
+	Checks []ActorTypeChecks `json:",omitempty"`
+	// Summaries holds the specs-actors state summary of each checked actor
+	// with one, such as *miner.StateSummary, by address.  It is only filled
+	// by Validate on v2 states and is not serialized.
+	Summaries map[address.Address]interface{} `json:"-"`
+}
+
+// ViolationGroup is all violations reported for one actor in one category.
+type ViolationGroup struct {
+	Address  string
+	Category string
+	Messages []string
+}
+
+// NewValidationResult parses the messages of acc into violations.  Messages
+// are expected to be prefixed like those of states2.CheckStateInvariants,
+// "<address> <actor type>: <message>".
+func NewValidationResult(stateRoot cid.Cid, epoch abi.ChainEpoch, duration time.Duration, acc *builtin2.MessageAccumulator) *ValidationResult {
+	result := &ValidationResult{
+		StateRoot: stateRoot,
+		Epoch:     epoch,
+		Duration:  duration,
+	}
+	for _, msg := range acc.Messages() {
+		result.Violations = append(result.Violations, parseViolation(msg))
+	}
+	return result
+}
+
+func parseViolation(msg string) Violation {
+	fields := strings.SplitN(msg, " ", 2)
+	if len(fields) < 2 {
+		return Violation{Category: CategoryTree, Message: msg}
+	}
+	if addr, err := address.NewFromString(fields[0]); err == nil {
+		rest := fields[1]
+		if i := strings.Index(rest, ": "); i > 0 && !strings.Contains(rest[:i], " ") {
+			return Violation{Address: addr.String(), Category: rest[:i], Message: rest[i+2:]}
+		}
+		return Violation{Address: addr.String(), Category: CategoryActor, Message: rest}
+	}
+	// Checks between miners and power name the miner second, "miner <address> ..."
+	if fields[0] == "miner" {
+		minerFields := strings.SplitN(fields[1], " ", 2)
+		if addr, err := address.NewFromString(minerFields[0]); err == nil {
+			return Violation{Address: addr.String(), Category: CategoryMinerPower, Message: msg}
+		}
+	}
+	return Violation{Category: CategoryTree, Message: msg}
+}
+
+var numberPattern = regexp.MustCompile(`[0-9]+`)
+
+// Invariant identifies the check that reported v independent of the actor
+// and values involved, by its category and message with all numbers
+// replaced by "N".
+func (v Violation) Invariant() string {
+	return v.Category + ": " + numberPattern.ReplaceAllString(v.Message, "N")
+}
+
+// Failed returns true if any violation not accepted by a baseline was
+// reported.
+func (r *ValidationResult) Failed() bool {
+	return len(r.Unaccepted()) > 0
+}
+
+// Unaccepted returns the violations of r not accepted by a baseline.
+func (r *ValidationResult) Unaccepted() []Violation {
+	var unaccepted []Violation
+	for _, v := range r.Violations {
+		if !v.Accepted {
+			unaccepted = append(unaccepted, v)
+		}
+	}
+	return unaccepted
+}
+
+// Groups returns the violations grouped by address and category, sorted by
+// address with violations of the whole tree first.
+func (r *ValidationResult) Groups() []ViolationGroup {
+	return GroupViolations(r.Violations)
+}
+
+// GroupViolations groups violations by address and category, sorted by
+// address with violations of the whole tree first.
+func GroupViolations(violations []Violation) []ViolationGroup {
+	index := make(map[[2]string]int)
+	var groups []ViolationGroup
+	for _, v := range violations {
+		key := [2]string{v.Address, v.Category}
+		i, found := index[key]
+		if !found {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, ViolationGroup{Address: v.Address, Category: v.Category})
+		}
+		groups[i].Messages = append(groups[i].Messages, v.Message)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].Address != groups[j].Address {
+			return groups[i].Address < groups[j].Address
+		}
+		return groups[i].Category < groups[j].Category
+	})
+	return groups
+}
+
+// AcceptBaseline marks the violations of r that are in baseline as accepted
+// and returns those that are not.  Violations match when their address,
+// category and invariant are the same, so a violation whose values changed
+// since the baseline was saved is still accepted.
+func (r *ValidationResult) AcceptBaseline(baseline []Violation) []Violation {
+	accepted := make(map[[3]string]struct{}, len(baseline))
+	for _, v := range baseline {
+		accepted[[3]string{v.Address, v.Category, v.Invariant()}] = struct{}{}
+	}
+	for i, v := range r.Violations {
+		if _, found := accepted[[3]string{v.Address, v.Category, v.Invariant()}]; found {
+			r.Violations[i].Accepted = true
+		}
+	}
+	return r.Unaccepted()
+}
+
+// LoadValidationResults reads validation results written by
+// WriteValidationResults.
+func LoadValidationResults(path string) ([]*ValidationResult, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read validation results %s: %w", path, err)
+	}
+	var results []*ValidationResult
+	if err := json.Unmarshal(raw, &results); err != nil {
+		return nil, xerrors.Errorf("failed to parse validation results %s: %w", path, err)
+	}
+	return results, nil
+}
+
+// LoadValidationBaseline reads the violations of all validation results
+// written to path by WriteValidationResults.
+func LoadValidationBaseline(path string) ([]Violation, error) {
+	results, err := LoadValidationResults(path)
+	if err != nil {
+		return nil, err
+	}
+	var baseline []Violation
+	for _, r := range results {
+		baseline = append(baseline, r.Violations...)
+	}
+	return baseline, nil
+}
+
+// WriteValidationResults writes validation results to path as JSON.
+func WriteValidationResults(path string, results []*ValidationResult) error {
+	raw, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, raw, 0644); err != nil {
+		return xerrors.Errorf("failed to write validation results %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package lib
+
+import "testing"
+
+func TestAcceptBaselineIgnoresNumbers(t *testing.T) {
+	result := &ValidationResult{Violations: []Violation{
+		{Address: "t01000", Category: "miner", Message: "sector 12 expires at 100"},
+		{Address: "t01001", Category: "miner", Message: "sector 12 expires at 100"},
+		{Address: "t01000", Category: "miner", Message: "deadline 3 has no partitions"},
+	}}
+	baseline := []Violation{
+		{Address: "t01000", Category: "miner", Message: "sector 7 expires at 90"},
+	}
+	unaccepted := result.AcceptBaseline(baseline)
+	if len(unaccepted) != 2 || unaccepted[0].Address != "t01001" || unaccepted[1].Message != "deadline 3 has no partitions" {
+		t.Fatalf("unexpected unaccepted violations %v", unaccepted)
+	}
+	if !result.Violations[0].Accepted || result.Violations[1].Accepted || result.Violations[2].Accepted {
+		t.Fatalf("unexpected accepted violations %v", result.Violations)
+	}
+	if !result.Failed() {
+		t.Fatal("expected result with unaccepted violations to fail")
+	}
+}
@@ -0,0 +1,97 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+
+	address "github.com/filecoin-project/go-address"
+	builtin0 "github.com/filecoin-project/specs-actors/actors/builtin"
+	states0 "github.com/filecoin-project/specs-actors/actors/states"
+	adt0 "github.com/filecoin-project/specs-actors/actors/util/adt"
+	builtin2 "github.com/filecoin-project/specs-actors/v2/actors/builtin"
+	states2 "github.com/filecoin-project/specs-actors/v2/actors/states"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"golang.org/x/xerrors"
+)
+
+// ActorsVersion is the major version of specs-actors whose code CIDs and
+// state encodings make up a state tree.
+type ActorsVersion int
+
+const (
+	ActorsVersion0 ActorsVersion = 0
+	ActorsVersion2 ActorsVersion = 2
+)
+
+func (v ActorsVersion) String() string {
+	return fmt.Sprintf("v%d", int(v))
+}
+
+// DetectActorsVersion reports the actors version of the actors HAMT at
+// actorsRoot by inspecting the code CID of the system actor.
+func DetectActorsVersion(ctx context.Context, store cbornode.IpldStore, actorsRoot cid.Cid) (ActorsVersion, error) {
+	// The actors HAMT is encoded identically in v0 and v2 so either
+	// version's tree can read the system actor.
+	tree, err := states2.LoadTree(adt0.WrapStore(ctx, store), actorsRoot)
+	if err != nil {
+		return 0, xerrors.Errorf("failed to load actors tree %s: %w", actorsRoot, err)
+	}
+	system, found, err := tree.GetActor(builtin2.SystemActorAddr)
+	if err != nil {
+		return 0, xerrors.Errorf("failed to load system actor from %s: %w", actorsRoot, err)
+	}
+	if !found {
+		return 0, xerrors.Errorf("system actor not found in %s", actorsRoot)
+	}
+	switch {
+	case system.Code.Equals(builtin0.SystemActorCodeID):
+		return ActorsVersion0, nil
+	case system.Code.Equals(builtin2.SystemActorCodeID):
+		return ActorsVersion2, nil
+	}
+	return 0, xerrors.Errorf("unknown system actor code %s in %s", system.Code, actorsRoot)
+}
+
+// ForEachActor calls fn for every actor in the actors tree at actorsRoot
+// regardless of the tree's actors version.
+func ForEachActor(ctx context.Context, store cbornode.IpldStore, actorsRoot cid.Cid, fn func(addr address.Address, a *states2.Actor) error) error {
+	version, err := DetectActorsVersion(ctx, store, actorsRoot)
+	if err != nil {
+		return err
+	}
+	adtStore := adt0.WrapStore(ctx, store)
+	switch version {
+	case ActorsVersion0:
+		tree, err := states0.LoadTree(adtStore, actorsRoot)
+		if err != nil {
+			return err
+		}
+		return tree.ForEach(fn)
+	case ActorsVersion2:
+		tree, err := states2.LoadTree(adtStore, actorsRoot)
+		if err != nil {
+			return err
+		}
+		return tree.ForEach(fn)
+	}
+	return xerrors.Errorf("unsupported actors version %s", version)
+}
+
+// LoadActor returns the actor at addr in the actors tree at actorsRoot
+// regardless of the tree's actors version.
+func LoadActor(ctx context.Context, store cbornode.IpldStore, actorsRoot cid.Cid, addr address.Address) (*states2.Actor, error) {
+	// The actors HAMT is encoded identically in v0 and v2.
+	tree, err := states2.LoadTree(adt0.WrapStore(ctx, store), actorsRoot)
+	if err != nil {
+		return nil, err
+	}
+	a, found, err := tree.GetActor(addr)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, xerrors.Errorf("actor %s not found in %s", addr, actorsRoot)
+	}
+	return a, nil
+}
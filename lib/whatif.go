@@ -0,0 +1,218 @@
+package lib
+
+import (
+	"context"
+	"reflect"
+	"sort"
+
+	address "github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-hamt-ipld/v2"
+	adt2 "github.com/filecoin-project/specs-actors/v2/actors/util/adt"
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-ipfs-blockstore"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"golang.org/x/xerrors"
+)
+
+// Bitwidths CollectionWhatIf can lay collections out with.
+const (
+	MinWhatIfBitwidth = 1
+	MaxWhatIfBitwidth = 8
+)
+
+// CollectionLayout is the measured structure of a collection laid out with
+// nodes of 2^Bitwidth slots.
+type CollectionLayout struct {
+	Bitwidth int
+	// Current is set for the layout of the collection in the state.
+	Current bool
+	Stats   *CollectionStats
+}
+
+// CollectionWhatIf measures the HAMT or AMT held in field of the state of
+// the actor at addr in tree, then rebuilds it with every bitwidth and
+// measures the result.  HAMTs are rebuilt in a scratch store with the HAMT
+// implementation of actors v2.  The AMT implementation of actors v2 only
+// supports nodes of 8 slots, so the layouts of AMTs with other bitwidths are
+// computed with layoutAMT instead.  The first layout returned is the
+// collection as it is in the state, the rest follow in bitwidth order.
+// Bitwidths must be between MinWhatIfBitwidth and MaxWhatIfBitwidth.
+func CollectionWhatIf(ctx context.Context, store cbornode.IpldStore, tree *StateTree, addr address.Address, field string, bitwidths []int) ([]CollectionLayout, error) {
+	for _, bw := range bitwidths {
+		if bw < MinWhatIfBitwidth || bw > MaxWhatIfBitwidth {
+			return nil, xerrors.Errorf("bitwidth %d out of range, must be between %d and %d", bw, MinWhatIfBitwidth, MaxWhatIfBitwidth)
+		}
+	}
+	a, found, err := tree.GetActor(addr)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, xerrors.Errorf("actor %s not found in %s", addr, tree.Root)
+	}
+	st, err := LoadActorState(ctx, store, a)
+	if err != nil {
+		return nil, err
+	}
+	link, found := stateLinks[reflect.TypeOf(st)][field]
+	if !found || link.kind == linkObject {
+		return nil, xerrors.Errorf("state of %s has no HAMT or AMT %s", addr, field)
+	}
+	root, ok := linkRoot(reflect.ValueOf(st).Elem().FieldByName(field))
+	if !ok || !root.Defined() {
+		return nil, xerrors.Errorf("field %s of %s does not hold a collection root", field, addr)
+	}
+
+	sort.Ints(bitwidths)
+	if link.kind == linkAMT {
+		var entries []amtEntry
+		current, err := MeasureAMT(ctx, store, root, func(i uint64, raw []byte) error {
+			entries = append(entries, amtEntry{i, len(raw)})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		layouts := []CollectionLayout{{Bitwidth: 3, Current: true, Stats: current}}
+		for _, bw := range bitwidths {
+			layouts = append(layouts, CollectionLayout{Bitwidth: bw, Stats: layoutAMT(entries, bw, root)})
+		}
+		return layouts, nil
+	}
+
+	type kv struct {
+		key string
+		raw []byte
+	}
+	var entries []kv
+	current, err := MeasureHAMT(ctx, store, root, actorsHAMTBitwidth, func(k string, raw []byte) error {
+		entries = append(entries, kv{k, raw})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	layouts := []CollectionLayout{{Bitwidth: actorsHAMTBitwidth, Current: true, Stats: current}}
+	for _, bw := range bitwidths {
+		scratch := cbornode.NewCborStore(blockstore.NewBlockstore(dssync.MutexWrap(datastore.NewMapDatastore())))
+		opts := append(append([]hamt.Option{}, adt2.HamtOptions...), hamt.UseTreeBitWidth(bw))
+		node := hamt.NewNode(scratch, opts...)
+		for _, e := range entries {
+			if err := node.SetRaw(ctx, e.key, e.raw); err != nil {
+				return nil, err
+			}
+		}
+		if err := node.Flush(ctx); err != nil {
+			return nil, err
+		}
+		rebuilt, err := scratch.Put(ctx, node)
+		if err != nil {
+			return nil, err
+		}
+		stats, err := MeasureHAMT(ctx, scratch, rebuilt, bw, nil)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to measure HAMT rebuilt with bitwidth %d: %w", bw, err)
+		}
+		layouts = append(layouts, CollectionLayout{Bitwidth: bw, Stats: stats})
+	}
+	return layouts, nil
+}
+
+type amtEntry struct {
+	index uint64
+	size  int
+}
+
+// layoutAMT computes the measurements of an AMT holding entries, ordered by
+// index, with nodes of 2^bitwidth slots.  Node sizes follow the actors v2
+// AMT encoding with the bitmap widened to one bit per slot, and links the
+// size of root.
+func layoutAMT(entries []amtEntry, bitwidth int, root cid.Cid) *CollectionStats {
+	s := &CollectionStats{Kind: "AMT", Instances: 1}
+	width := uint64(1) << uint(bitwidth)
+	var height uint64
+	if len(entries) > 0 {
+		for max := entries[len(entries)-1].index; max >= width; max /= width {
+			height++
+		}
+	}
+	s.Depth = int(height) + 1
+
+	bmapBytes := int(width+7) / 8
+	linkBytes := 2 + cborHeaderSize(uint64(root.ByteLen()+1)) + root.ByteLen() + 1
+	// The size of each node by level, from the leaves up, and by the index
+	// of its entries divided by the indexes it spans.
+	nodeSizes := make([]map[uint64]int, height+1)
+	for level := range nodeSizes {
+		nodeSizes[level] = make(map[uint64]int)
+	}
+	span := func(level uint64) uint64 {
+		n := width
+		for ; level > 0; level-- {
+			n *= width
+		}
+		return n
+	}
+	leafValues := make(map[uint64]int)
+	for _, e := range entries {
+		leaf := e.index / width
+		nodeSizes[0][leaf] += e.size
+		leafValues[leaf]++
+		s.Entries++
+		s.Values.Add(e.size)
+	}
+	for leaf, values := range leafValues {
+		// array header, bitmap, empty links and values
+		nodeSizes[0][leaf] += 1 + cborHeaderSize(uint64(bmapBytes)) + bmapBytes + 1 + cborHeaderSize(uint64(values))
+		s.UsedSlots += uint64(values)
+	}
+	for level := uint64(1); level <= height; level++ {
+		links := make(map[uint64]int)
+		for child := range nodeSizes[level-1] {
+			links[child/width]++
+		}
+		for node, n := range links {
+			nodeSizes[level][node] = 1 + cborHeaderSize(uint64(bmapBytes)) + bmapBytes + cborHeaderSize(uint64(n)) + n*linkBytes + 1
+			s.UsedSlots += uint64(n)
+		}
+	}
+	for _, sizes := range nodeSizes {
+		for _, size := range sizes {
+			s.Nodes++
+			s.Bytes += uint64(size)
+		}
+	}
+	// The root node is held in the root block with the height and count.
+	rootExtra := 1 + cborHeaderSize(height) + cborHeaderSize(s.Entries)
+	if len(entries) == 0 {
+		s.Nodes = 1
+		s.Bytes = uint64(1 + cborHeaderSize(uint64(bmapBytes)) + bmapBytes + 2)
+	}
+	s.Bytes += uint64(rootExtra)
+	s.Slots = s.Nodes * width
+	for _, e := range entries {
+		s.PathBlocks += uint64(s.Depth)
+		s.PathBytes += uint64(rootExtra)
+		for level := uint64(0); level <= height; level++ {
+			s.PathBytes += uint64(nodeSizes[level][e.index/span(level)])
+		}
+	}
+	return s
+}
+
+// cborHeaderSize returns the size of a CBOR major type header holding n.
+func cborHeaderSize(n uint64) int {
+	switch {
+	case n < 24:
+		return 1
+	case n < 1<<8:
+		return 2
+	case n < 1<<16:
+		return 3
+	case n < 1<<32:
+		return 5
+	}
+	return 9
+}
@@ -0,0 +1,68 @@
+package lib
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	adt2 "github.com/filecoin-project/specs-actors/v2/actors/util/adt"
+)
+
+// TestLayoutAMTMatchesMeasured checks the layout computed for the bitwidth of
+// the actors v2 AMT against the measurements of the AMT itself.
+func TestLayoutAMTMatchesMeasured(t *testing.T) {
+	ctx := context.Background()
+	store := newMemCborStore()
+	adtStore := adt2.WrapStore(ctx, store)
+	for _, indexes := range [][]uint64{
+		{},
+		{0, 3, 7},
+		{1, 8, 9, 63},
+		{0, 5, 70, 600, 601, 4000},
+	} {
+		values := make(map[uint64]int64)
+		for _, i := range indexes {
+			values[i] = int64(i) * 1000
+		}
+		root := testAMT(t, adtStore, values)
+		var entries []amtEntry
+		measured, err := MeasureAMT(ctx, store, root, func(i uint64, raw []byte) error {
+			entries = append(entries, amtEntry{i, len(raw)})
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		computed := layoutAMT(entries, 3, root)
+		if !reflect.DeepEqual(computed, measured) {
+			t.Fatalf("indexes %v: computed layout %+v, measured %+v", indexes, computed, measured)
+		}
+	}
+}
+
+func TestLayoutAMTBitwidths(t *testing.T) {
+	store := newMemCborStore()
+	root := testAMT(t, adt2.WrapStore(context.Background(), store), map[uint64]int64{0: 0})
+	var entries []amtEntry
+	for i := uint64(0); i < 40; i++ {
+		entries = append(entries, amtEntry{i, 3})
+	}
+	// 40 entries fill two leaves of 32 slots under a root
+	wide := layoutAMT(entries, 5, root)
+	if wide.Depth != 2 || wide.Nodes != 3 || wide.Slots != 96 || wide.UsedSlots != 42 || wide.PathBlocks != 80 {
+		t.Fatalf("unexpected layout with bitwidth 5: %+v", wide)
+	}
+	// and six levels of nodes of 2 slots
+	narrow := layoutAMT(entries, 1, root)
+	if narrow.Depth != 6 || narrow.Entries != 40 || narrow.UsedSlots != narrow.Nodes-1+40 {
+		t.Fatalf("unexpected layout with bitwidth 1: %+v", narrow)
+	}
+}
+
+func TestCollectionWhatIfRejectsBitwidths(t *testing.T) {
+	for _, bw := range []int{-1, 0, 9} {
+		if _, err := CollectionWhatIf(context.Background(), newMemCborStore(), nil, testIDAddress(1000), "Sectors", []int{3, bw}); err == nil {
+			t.Fatalf("expected bitwidth %d to be rejected", bw)
+		}
+	}
+}